@@ -6,29 +6,50 @@ import (
 	redis2 "github.com/go-redis/redis/v8"
 	"github.com/meehow/securebytes"
 	"github.com/vuuvv/errors"
+	"github.com/vuuvv/orca/cache"
 	"github.com/vuuvv/orca/config"
 	"github.com/vuuvv/orca/id"
 	"github.com/vuuvv/orca/logger"
+	"github.com/vuuvv/orca/migrate"
 	"github.com/vuuvv/orca/orm"
-	"github.com/vuuvv/orca/redis"
 	"github.com/vuuvv/orca/redislock"
 	"github.com/vuuvv/orca/secure"
 	"github.com/vuuvv/orca/serialize"
 	"github.com/vuuvv/orca/server"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"os"
+	"sync"
 	"time"
 )
 
 type Application struct {
+	ctx          context.Context
+	cancel       context.CancelFunc
+	config       *Config
 	configPath   string
 	configLoader config.Loader
 	httpServer   server.Server
+	healthServer *server.HealthServer
 	logger       *zap.Logger
 	redisClient  *redis2.Client
 	db           *gorm.DB
+	databases    map[string]*gorm.DB
 	idGenerator  *id.Generator
+	cacheStore   *cache.Store
 	secure       *securebytes.SecureBytes
+	startHooks   []*hook
+	stopHooks    []*hook
+	stopOnce     sync.Once
+	stopErr      error
+	workerMu     sync.Mutex
+	workerWg     sync.WaitGroup
+	workers      []*worker
+	started      bool
+
+	extraComponents []*Component
+	components      map[string]interface{}
+	initialized     []*initializedComponent
 }
 
 type ApplicationOption func(app *Application)
@@ -57,83 +78,125 @@ func WithLogger(logger *zap.Logger) ApplicationOption {
 	}
 }
 
+// WithShutdownTimeout 关闭应用的最长时间，配置文件中设置了app.shutdownTimeout时以配置文件为准
+func WithShutdownTimeout(timeout time.Duration) ApplicationOption {
+	return func(app *Application) {
+		app.config.ShutdownTimeout = timeout
+	}
+}
+
+// WithWorkerMode 以worker模式运行，不启动http服务，只运行后台任务。
+// healthAddr不为空时启动一个只有_m_/health的健康检测服务
+func WithWorkerMode(healthAddr string) ApplicationOption {
+	return func(app *Application) {
+		app.config.Mode = ModeWorker
+		app.config.HealthAddr = healthAddr
+	}
+}
+
 func NewApplication(opts ...ApplicationOption) *Application {
+	app, err := NewApplicationE(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return app
+}
+
+// NewApplicationE 创建应用，初始化失败时返回*StartupError，并关闭已经初始化的组件
+func NewApplicationE(opts ...ApplicationOption) (_ *Application, err error) {
 	app := &Application{
+		config:       &Config{},
 		configPath:   "resources/application.yaml",
 		configLoader: config.NewViperConfigLoader(),
 	}
+	app.ctx, app.cancel = context.WithCancel(context.Background())
 	for _, opt := range opts {
 		opt(app)
 	}
 
+	defer func() {
+		if err != nil {
+			app.release()
+		}
+	}()
+
 	// jsoniter序列化初始化
 	serialize.InitializeJsoniter()
 
 	// 配置加载器初始化
-	err := app.configLoader.Load(app.configPath)
+	err = app.configLoader.Load(app.configPath)
 	if err != nil {
-		panic(err)
+		return nil, startupError("config", err)
+	}
+
+	// 应用配置
+	if app.configLoader.IsSet("app") {
+		err = app.UnmarshalConfig(app.config, "app")
+		if err != nil {
+			return nil, startupError("app", err)
+		}
 	}
 
 	// 日志初始化
 	zapConfig := &logger.Config{}
 	err = app.UnmarshalConfig(zapConfig, "zap")
 	if err != nil {
-		panic(err)
+		return nil, startupError("zap", err)
 	}
-	app.logger = logger.NewLogger(zapConfig)
-
-	// redis初始化
-	if app.configLoader.IsSet("redis") {
-		redisConfig := &redis.Config{}
-		err = app.UnmarshalConfig(redisConfig, "redis")
-		if err != nil {
-			panic(err)
-		}
-		app.redisClient, err = redis.NewRedisClient(redisConfig)
-		if err != nil {
-			panic(err)
-		}
-		redis.SetClient(app.redisClient)
-	}
-
-	if app.redisClient != nil {
-		app.idGenerator, err = id.NewGenerator(id.WithRedisClient(app.redisClient))
-		if err != nil {
-			panic(err)
-		}
-	} else {
-		app.idGenerator, err = id.NewGenerator()
+	app.logger, err = logger.New(zapConfig)
+	if err != nil {
+		return nil, startupError("zap", err)
 	}
 
-	// gorm初始化
-	if app.configLoader.IsSet("database") {
-		databaseConfig := &orm.Config{}
-		err = app.UnmarshalConfig(databaseConfig, "database")
-		if err != nil {
-			panic(err)
-		}
-		app.db, err = orm.New(databaseConfig)
-		if err != nil {
-			panic(err)
-		}
+	// 组件初始化
+	err = app.initComponents()
+	if err != nil {
+		return nil, err
 	}
 
 	// http服务器初始化
 	httpConfig := &server.Config{}
 	err = app.UnmarshalConfig(httpConfig, "http")
 	if err != nil {
-		panic(err)
+		return nil, startupError("http", err)
+	}
+	if app.IsWorkerMode() {
+		if app.config.HealthAddr != "" {
+			app.healthServer = server.NewHealthServer(app.config.HealthAddr)
+		}
+	} else {
+		if app.httpServer == nil {
+			app.httpServer = server.NewGinServer(httpConfig)
+		}
+		if infoServer, ok := app.httpServer.(server.InfoServer); ok {
+			infoServer.AddInfo("workers", func() interface{} {
+				return app.Workers()
+			})
+		}
 	}
-	app.httpServer = server.NewGinServer(httpConfig)
+
+	app.monitorDatabases()
+	app.registerStopHooks()
 
 	if defaultApplication == nil {
 		ReplaceDefaultApplication(app)
 	}
 
-	secure.SetSecure(secure.NewSecure(httpConfig.JwtSecret))
+	orm.SetUserProvider(server.AccessUserId)
+	orm.SetRequestIdProvider(server.AccessRequestId)
 
-	return app
+	if app.httpServer != nil {
+		secure.SetSecure(secure.NewSecure(app.httpServer.GetConfig().JwtSecret))
+	} else if httpConfig.JwtSecret != "" {
+		secure.SetSecure(secure.NewSecure(httpConfig.JwtSecret))
+	}
+
+	return app, nil
+}
+
+// IsWorkerMode 是否以worker模式运行
+func (this *Application) IsWorkerMode() bool {
+	return this.config.Mode == ModeWorker
 }
 
 func (this *Application) GetConfig(name string) interface{} {
@@ -157,12 +220,49 @@ func (this *Application) GetHttpServer() server.Server {
 	return this.httpServer
 }
 
+// Start 执行启动钩子，启动后台任务和http服务，阻塞直到收到SIGINT、SIGTERM或http服务出错，然后关闭应用。
+// worker模式下不启动http服务
 func (this *Application) Start() {
-	if this.httpServer == nil {
+	if this.httpServer == nil && !this.IsWorkerMode() {
 		panic("Application start error: http server is nil")
 	}
 
-	this.httpServer.Start()
+	if err := this.runStartHooks(); err != nil {
+		_ = this.Stop()
+		panic(err)
+	}
+	this.startWorkers()
+
+	serveErr := make(chan error, 1)
+	if graceful, ok := this.httpServer.(server.GracefulServer); ok {
+		go func() {
+			serveErr <- graceful.Serve()
+		}()
+	} else if this.httpServer != nil {
+		go func() {
+			this.httpServer.Start()
+			serveErr <- nil
+		}()
+	} else if this.healthServer != nil {
+		go func() {
+			serveErr <- this.healthServer.Serve()
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	go func() {
+		sig <- waitSignal()
+	}()
+
+	select {
+	case s := <-sig:
+		zap.L().Info("收到退出信号", zap.String("signal", s.String()))
+	case err := <-serveErr:
+		if err != nil {
+			zap.L().Error("http服务异常退出", zap.Error(err))
+		}
+	}
+	_ = this.Stop()
 }
 
 func (this *Application) Use(handlers ...interface{}) *Application {
@@ -206,6 +306,33 @@ func Database() *gorm.DB {
 	return defaultApplication.db
 }
 
+// DatabaseNamed 获取databases下配置的命名数据库，name为空时返回默认数据库，未配置时panic
+func (this *Application) DatabaseNamed(name string) *gorm.DB {
+	if name == "" {
+		return this.db
+	}
+	db, ok := this.databases[name]
+	if !ok {
+		panic("数据库[" + name + "]未配置")
+	}
+	return db
+}
+
+// DatabaseNamed 从默认应用获取命名数据库
+func DatabaseNamed(name string) *gorm.DB {
+	return defaultApplication.DatabaseNamed(name)
+}
+
+func Cache() *cache.Store {
+	return defaultApplication.cacheStore
+}
+
+// Migrator 获取数据库迁移，未配置migrate时返回nil
+func Migrator() *migrate.Migrator {
+	migrator, _ := ComponentOf[*migrate.Migrator](defaultApplication, ComponentMigrate)
+	return migrator
+}
+
 func RedisLock(ctx context.Context, key string, ttl time.Duration, opt *redislock.Options) (*redislock.Lock, error) {
 	locker := redislock.New(Redis())
 	lock, err := locker.Obtain(ctx, key, ttl, opt)
@@ -213,7 +340,7 @@ func RedisLock(ctx context.Context, key string, ttl time.Duration, opt *redisloc
 }
 
 func Transaction(fc func(tx *gorm.DB) error, opts ...*sql.TxOptions) error {
-	return errors.WithStack(defaultApplication.db.Transaction(fc, opts...))
+	return orm.RunTx(defaultApplication.db, fc, opts...)
 }
 
 func Use(handlers ...interface{}) *Application {
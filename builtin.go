@@ -0,0 +1,305 @@
+package orca
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	redis2 "github.com/go-redis/redis/v8"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/orca/cache"
+	"github.com/vuuvv/orca/id"
+	"github.com/vuuvv/orca/migrate"
+	"github.com/vuuvv/orca/orm"
+	"github.com/vuuvv/orca/redis"
+	"github.com/vuuvv/orca/server"
+	"github.com/vuuvv/orca/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	ComponentRedis     = "redis"
+	ComponentId        = "id"
+	ComponentCache     = "cache"
+	ComponentDatabase  = "database"
+	ComponentDatabases = "databases"
+	ComponentMigrate   = "migrate"
+)
+
+// builtinComponents orca自带的组件，可以注册同名组件替换，替换组件的值须与内置组件的类型相同，
+// 例如redis为*redis.Client，database为*gorm.DB，见bindBuiltin
+func builtinComponents() []*Component {
+	return []*Component{
+		{
+			Name:      ComponentRedis,
+			ConfigKey: "redis",
+			Retry:     true,
+			Init: func(app *Application) (interface{}, error) {
+				redisConfig := &redis.Config{}
+				err := app.UnmarshalConfig(redisConfig, "redis")
+				if err != nil {
+					return nil, err
+				}
+				client, err := redis.NewRedisClient(redisConfig)
+				if err != nil {
+					return nil, err
+				}
+				return client, nil
+			},
+			Close: func(ctx context.Context, value interface{}) error {
+				return errors.WithStack(value.(*redis2.Client).Close())
+			},
+		},
+		{
+			Name:      ComponentId,
+			DependsOn: []string{ComponentRedis},
+			Init: func(app *Application) (interface{}, error) {
+				var opts []id.Option
+				if app.redisClient != nil {
+					opts = append(opts, id.WithRedisClient(app.redisClient))
+				}
+				generator, err := id.NewGenerator(opts...)
+				if err != nil {
+					return nil, err
+				}
+				return generator, nil
+			},
+			Close: func(ctx context.Context, value interface{}) error {
+				return value.(*id.Generator).Close(ctx)
+			},
+			CloseOrder: OrderId,
+		},
+		{
+			Name:      ComponentCache,
+			ConfigKey: "cache",
+			DependsOn: []string{ComponentRedis},
+			Init: func(app *Application) (interface{}, error) {
+				cacheConfig := &cache.Config{}
+				err := app.UnmarshalConfig(cacheConfig, "cache")
+				if err != nil {
+					return nil, err
+				}
+				store := cache.NewStore(cacheConfig, app.redisClient)
+				if app.redisClient != nil {
+					app.Go("cache-invalidation", store.Subscribe)
+				}
+				return store, nil
+			},
+		},
+		{
+			Name:      ComponentDatabase,
+			ConfigKey: "database",
+			DependsOn: []string{ComponentRedis},
+			Retry:     true,
+			Init: func(app *Application) (interface{}, error) {
+				databaseConfig := &orm.Config{}
+				err := app.UnmarshalConfig(databaseConfig, "database")
+				if err != nil {
+					return nil, err
+				}
+				db, err := newDatabase(app, databaseConfig)
+				if err != nil {
+					return nil, err
+				}
+				if err = useSequence(app, db, databaseConfig); err != nil {
+					_ = closeDatabase(db)
+					return nil, err
+				}
+				return db, nil
+			},
+			Close: func(ctx context.Context, value interface{}) error {
+				return closeDatabase(value.(*gorm.DB))
+			},
+		},
+		{
+			Name:      ComponentDatabases,
+			ConfigKey: "databases",
+			DependsOn: []string{ComponentRedis},
+			Retry:     true,
+			Init: func(app *Application) (value interface{}, err error) {
+				configs := map[string]*orm.Config{}
+				err = app.UnmarshalConfig(&configs, "databases")
+				if err != nil {
+					return nil, err
+				}
+				databases := make(map[string]*gorm.DB, len(configs))
+				defer func() {
+					if err != nil {
+						for _, db := range databases {
+							_ = closeDatabase(db)
+						}
+					}
+				}()
+				for name, databaseConfig := range configs {
+					var db *gorm.DB
+					db, err = newDatabase(app, databaseConfig)
+					if err != nil {
+						return nil, errors.Wrapf(err, "数据库[%s]初始化失败: %v", name, err)
+					}
+					databases[name] = db
+				}
+				return databases, nil
+			},
+			Close: func(ctx context.Context, value interface{}) (err error) {
+				for name, db := range value.(map[string]*gorm.DB) {
+					if e := closeDatabase(db); e != nil && err == nil {
+						err = errors.Wrapf(e, "关闭数据库[%s]失败: %v", name, e)
+					}
+				}
+				return err
+			},
+		},
+		{
+			Name:      ComponentMigrate,
+			ConfigKey: "migrate",
+			DependsOn: []string{ComponentDatabase, ComponentRedis},
+			Init: func(app *Application) (interface{}, error) {
+				if app.db == nil {
+					return nil, errors.New("数据库迁移需要配置database")
+				}
+				migrateConfig := &migrate.Config{}
+				err := app.UnmarshalConfig(migrateConfig, "migrate")
+				if err != nil {
+					return nil, err
+				}
+				migrator, err := migrate.New(app.db, migrateConfig, migrate.WithRedisClient(app.redisClient))
+				if err != nil {
+					return nil, err
+				}
+				if migrateConfig.Auto {
+					err = migrator.Up(app.ctx)
+					if err != nil {
+						return nil, err
+					}
+				}
+				return migrator, nil
+			},
+		},
+	}
+}
+
+// bindBuiltin 保存内置组件的值，Redis()、DB()等和依赖它们的组件使用该值，替换内置组件时同样生效
+func (this *Application) bindBuiltin(name string, value interface{}) error {
+	var ok bool
+	switch name {
+	case ComponentRedis:
+		if this.redisClient, ok = value.(*redis2.Client); ok {
+			redis.SetClient(this.redisClient)
+		}
+	case ComponentId:
+		this.idGenerator, ok = value.(*id.Generator)
+	case ComponentCache:
+		if this.cacheStore, ok = value.(*cache.Store); ok {
+			cache.SetStore(this.cacheStore)
+		}
+	case ComponentDatabase:
+		this.db, ok = value.(*gorm.DB)
+	case ComponentDatabases:
+		this.databases, ok = value.(map[string]*gorm.DB)
+	default:
+		return nil
+	}
+	if !ok {
+		return errors.Errorf("组件[%s]的类型%T与内置组件不一致", name, value)
+	}
+	return nil
+}
+
+// newDatabase 创建数据库，配置了changeLogStream时将变更历史发布到redis
+func newDatabase(app *Application, config *orm.Config) (*gorm.DB, error) {
+	db, err := orm.New(config)
+	if err != nil || config.ChangeLogStream == "" {
+		return db, err
+	}
+	if app.redisClient == nil {
+		_ = closeDatabase(db)
+		return nil, errors.New("changeLogStream需要配置redis")
+	}
+	if err = orm.UseChangeRecorder(db, orm.StreamRecorder(app.redisClient, config.ChangeLogStream, config.ChangeLogStreamMaxLen)); err != nil {
+		_ = closeDatabase(db)
+		return nil, err
+	}
+	return db, nil
+}
+
+// useSequence 按默认数据库的配置设置生成树节点code的序列和编码
+func useSequence(app *Application, db *gorm.DB, config *orm.Config) error {
+	switch config.Sequence {
+	case "", "db":
+		if config.SequenceBlock > 1 {
+			orm.UseSequenceBackend(orm.DBSequence(db), config.SequenceBlock)
+		}
+	case "redis":
+		if app.redisClient == nil {
+			return errors.New("sequence为redis时需要配置redis")
+		}
+		orm.UseSequenceBackend(orm.RedisSequence(app.redisClient), config.SequenceBlock)
+	case "postgres":
+		orm.UseSequenceBackend(orm.PostgresSequence(db), config.SequenceBlock)
+	default:
+		return errors.Errorf("不支持的sequence: %s", config.Sequence)
+	}
+
+	switch config.CodeCodec {
+	case "", "base64":
+	case "sortable":
+		width := config.CodeWidth
+		if width == 0 {
+			width = 3
+		}
+		codec, err := utils.NewSortableCodec("", width)
+		if err != nil {
+			return err
+		}
+		orm.UseCodeCodec(codec)
+	default:
+		return errors.Errorf("不支持的codeCodec: %s", config.CodeCodec)
+	}
+	return nil
+}
+
+func closeDatabase(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(sqlDB.Close())
+}
+
+// monitorDatabases 通过_m_/info展示数据库及其副本连接池的统计信息，并将数据库ping加入健康检测
+func (this *Application) monitorDatabases() {
+	databases := make(map[string]*gorm.DB, len(this.databases)+1)
+	if this.db != nil {
+		databases["default"] = this.db
+	}
+	for name, db := range this.databases {
+		databases[name] = db
+	}
+	if len(databases) == 0 {
+		return
+	}
+
+	for name, db := range databases {
+		db := db
+		ping := func(ctx context.Context) error {
+			return orm.Ping(ctx, db)
+		}
+		if checkServer, ok := this.httpServer.(server.HealthCheckServer); ok {
+			checkServer.AddHealthCheck("database:"+name, ping)
+		}
+		if this.healthServer != nil {
+			this.healthServer.AddHealthCheck("database:"+name, ping)
+		}
+	}
+	if infoServer, ok := this.httpServer.(server.InfoServer); ok {
+		infoServer.AddInfo("database", func() interface{} {
+			ret := make(map[string]sql.DBStats, len(databases))
+			for name, db := range databases {
+				ret[name] = orm.Stats(db)
+				for i, stats := range orm.ReplicaStats(db) {
+					ret[fmt.Sprintf("%s:replica%d", name, i)] = stats
+				}
+			}
+			return ret
+		})
+	}
+}
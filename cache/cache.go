@@ -1 +1,488 @@
 package cache
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/orca/redislock"
+	"github.com/vuuvv/orca/serialize"
+	"github.com/vuuvv/orca/utils"
+	"go.uber.org/zap"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Store 缓存的存储后端，持有redis客户端和缓存配置，redis客户端为空时只使用进程内缓存
+type Store struct {
+	id     string
+	config *Config
+	client *redis.Client
+	mu     sync.RWMutex
+	caches map[string][]localCache
+}
+
+func NewStore(config *Config, client *redis.Client) *Store {
+	if config == nil {
+		config = &Config{}
+	}
+
+	if config.Prefix == "" {
+		config.Prefix = "cache"
+	}
+
+	if config.TTL == 0 {
+		config.TTL = 10 * time.Minute
+	}
+
+	if config.LocalSize == 0 {
+		config.LocalSize = 1000
+	}
+
+	if config.LocalTTL == 0 {
+		config.LocalTTL = time.Minute
+	}
+
+	if config.LocalTTL > config.TTL {
+		config.LocalTTL = config.TTL
+	}
+
+	return &Store{
+		id:     newInstanceId(),
+		config: config,
+		client: client,
+		caches: make(map[string][]localCache),
+	}
+}
+
+func (s *Store) GetConfig() *Config {
+	return s.config
+}
+
+func (s *Store) GetClient() *redis.Client {
+	return s.client
+}
+
+var store *Store
+
+func GetStore() *Store {
+	return store
+}
+
+func SetStore(s *Store) {
+	store = s
+}
+
+type options struct {
+	store     *Store
+	ttl       time.Duration
+	localTTL  time.Duration
+	localSize int
+	lockTTL   time.Duration
+	beta      float64
+}
+
+type Option func(o *options)
+
+// WithStore 指定存储后端，默认使用全局的Store
+func WithStore(store *Store) Option {
+	return func(o *options) {
+		o.store = store
+	}
+}
+
+// WithTTL 缓存的默认过期时间
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithLocal 进程内缓存的容量和过期时间，size小于0表示不启用进程内缓存
+func WithLocal(size int, ttl time.Duration) Option {
+	return func(o *options) {
+		o.localSize = size
+		o.localTTL = ttl
+	}
+}
+
+// WithLock GetOrLoad加载数据时使用redislock加锁，多个实例中只有一个会执行loader，
+// 其余实例在ttl内等待缓存被写入。ttl应大于loader的最长执行时间
+func WithLock(ttl time.Duration) Option {
+	return func(o *options) {
+		o.lockTTL = ttl
+	}
+}
+
+// WithEarlyRefresh 启用概率提前刷新(XFetch)，beta越大越早刷新，一般取1
+func WithEarlyRefresh(beta float64) Option {
+	return func(o *options) {
+		o.beta = beta
+	}
+}
+
+// Loader 缓存未命中时加载数据的函数
+type Loader[T any] func(ctx context.Context) (T, error)
+
+// entry 实际存储的缓存内容，Delta和ExpireAt用于提前刷新的计算
+type entry[T any] struct {
+	Value T `json:"v"`
+	// Delta 加载数据花费的时间，毫秒
+	Delta int64 `json:"d,omitempty"`
+	// ExpireAt 过期时间，unix毫秒
+	ExpireAt int64 `json:"e"`
+}
+
+// Cache 带类型的二级缓存，进程内LRU缓存在前，redis在后
+type Cache[T any] struct {
+	name   string
+	opts   *options
+	once   sync.Once
+	store  *Store
+	local  *lru[*entry[T]]
+	flight flight[T]
+}
+
+// New 创建缓存，name作为redis key的命名空间。
+// 可以在包初始化时创建，全局Store在第一次使用时才会读取
+func New[T any](name string, opts ...Option) *Cache[T] {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &Cache[T]{
+		name: name,
+		opts: o,
+	}
+}
+
+func (c *Cache[T]) init() {
+	c.once.Do(func() {
+		c.store = c.opts.store
+		if c.store == nil {
+			c.store = GetStore()
+		}
+		if c.store == nil {
+			c.store = NewStore(nil, nil)
+		}
+
+		config := c.store.config
+		if c.opts.ttl == 0 {
+			c.opts.ttl = config.TTL
+		}
+		if c.opts.localSize == 0 {
+			c.opts.localSize = config.LocalSize
+		}
+		if c.opts.localTTL == 0 {
+			c.opts.localTTL = config.LocalTTL
+		}
+		if c.opts.localSize > 0 {
+			c.local = newLru[*entry[T]](c.opts.localSize)
+			c.store.register(c)
+		}
+	})
+}
+
+func (c *Cache[T]) Name() string {
+	return c.name
+}
+
+// Key 返回redis中实际使用的key
+func (c *Cache[T]) Key(key string) string {
+	c.init()
+	return c.store.key(c.name, key)
+}
+
+// Keys 返回用于失效的key集合，可传给orm.Invalidate
+func (c *Cache[T]) Keys(keys ...string) *Keys {
+	return &Keys{Cache: c.name, Keys: keys}
+}
+
+func (c *Cache[T]) evictLocal(keys ...string) {
+	if c.local == nil {
+		return
+	}
+	if len(keys) == 0 {
+		c.local.purge()
+		return
+	}
+	c.local.remove(keys...)
+}
+
+func (c *Cache[T]) expiration(ttl []time.Duration) time.Duration {
+	if len(ttl) > 0 && ttl[0] > 0 {
+		return ttl[0]
+	}
+	return c.opts.ttl
+}
+
+func (c *Cache[T]) newEntry(value T, expiration time.Duration, delta time.Duration) *entry[T] {
+	return &entry[T]{
+		Value:    value,
+		Delta:    delta.Milliseconds(),
+		ExpireAt: time.Now().Add(expiration).UnixMilli(),
+	}
+}
+
+func (c *Cache[T]) setLocal(key string, e *entry[T]) {
+	if c.local == nil {
+		return
+	}
+	// 没有redis时进程内缓存就是唯一的一级
+	localTTL := time.Until(time.UnixMilli(e.ExpireAt))
+	if c.store.client != nil && c.opts.localTTL < localTTL {
+		localTTL = c.opts.localTTL
+	}
+	if localTTL <= 0 {
+		return
+	}
+	c.local.set(key, e, localTTL)
+}
+
+func (c *Cache[T]) getEntry(ctx context.Context, key string) (*entry[T], error) {
+	c.init()
+	if c.local != nil {
+		if e, ok := c.local.get(key); ok {
+			return e, nil
+		}
+	}
+
+	if c.store.client == nil {
+		return nil, errors.WithStack(redis.Nil)
+	}
+
+	body, err := c.store.client.Get(ctx, c.Key(key)).Bytes()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	e, err := serialize.JsonParseBytes[entry[T]](body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	c.setLocal(key, e)
+	return e, nil
+}
+
+func (c *Cache[T]) setEntry(ctx context.Context, key string, e *entry[T], expiration time.Duration) error {
+	if c.store.client != nil {
+		body, err := serialize.JsonStringifyBytes(e)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		err = c.store.client.Set(ctx, c.Key(key), body, expiration).Err()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	c.setLocal(key, e)
+	return nil
+}
+
+// shouldRefresh XFetch算法：越接近过期、加载越慢的数据，越有可能被提前刷新
+func (c *Cache[T]) shouldRefresh(e *entry[T]) bool {
+	if c.opts.beta <= 0 || e.Delta <= 0 {
+		return false
+	}
+	gap := -float64(e.Delta) * c.opts.beta * math.Log(1-rand.Float64())
+	return time.Now().UnixMilli()+int64(gap) >= e.ExpireAt
+}
+
+// Get 获取缓存，未命中时返回redis.Nil，可用utils.RecordNotFound判断
+func (c *Cache[T]) Get(ctx context.Context, key string) (value T, err error) {
+	e, err := c.getEntry(ctx, key)
+	if err != nil {
+		return value, err
+	}
+	return e.Value, nil
+}
+
+// Set 设置缓存，ttl为空时使用默认过期时间，其他实例的进程内缓存会被删除
+func (c *Cache[T]) Set(ctx context.Context, key string, value T, ttl ...time.Duration) error {
+	c.init()
+	expiration := c.expiration(ttl)
+	err := c.setEntry(ctx, key, c.newEntry(value, expiration, 0), expiration)
+	if err != nil {
+		return err
+	}
+	return c.store.publish(ctx, c.Keys(key))
+}
+
+// Delete 删除缓存，其他实例的进程内缓存也会被删除
+func (c *Cache[T]) Delete(ctx context.Context, keys ...string) error {
+	c.init()
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.store.Invalidate(ctx, c.Keys(keys...))
+}
+
+// Purge 清空所有实例的进程内缓存，redis中的缓存不受影响
+func (c *Cache[T]) Purge(ctx context.Context) error {
+	c.init()
+	return c.store.Invalidate(ctx, c.Keys())
+}
+
+// GetOrLoad 获取缓存，未命中时调用loader加载并写入缓存。
+// 同一进程内同一个key的并发加载会被合并，loader使用第一个调用者的ctx执行；
+// 配置了WithLock时多个实例间也只有一个会执行loader。
+// 缓存读写出错时只记录日志，不影响loader的结果
+func (c *Cache[T]) GetOrLoad(ctx context.Context, key string, loader Loader[T], ttl ...time.Duration) (value T, err error) {
+	e, err := c.getEntry(ctx, key)
+	if err == nil && !c.shouldRefresh(e) {
+		return e.Value, nil
+	}
+	if err != nil && !utils.RecordNotFound(err) {
+		zap.L().Warn("读取缓存失败", zap.String("cache", c.name), zap.String("key", key), zap.Error(err))
+	}
+
+	value, err = c.flight.do(key, func() (T, error) {
+		// 等待合并期间上一次加载可能已经完成
+		if e == nil {
+			if loaded, err := c.getEntry(ctx, key); err == nil {
+				return loaded.Value, nil
+			}
+		}
+		return c.load(ctx, key, e, loader, c.expiration(ttl))
+	})
+	if err != nil && e != nil {
+		// 提前刷新失败时旧数据仍然有效
+		zap.L().Warn("提前刷新缓存失败", zap.String("cache", c.name), zap.String("key", key), zap.Error(err))
+		return e.Value, nil
+	}
+	return value, err
+}
+
+// load 执行loader并写入缓存，stale为提前刷新时的旧数据，缓存未命中时为空
+func (c *Cache[T]) load(ctx context.Context, key string, stale *entry[T], loader Loader[T], expiration time.Duration) (value T, err error) {
+	if c.opts.lockTTL > 0 && c.store.client != nil {
+		lock, err := redislock.New(c.store.client).Obtain(ctx, c.Key(key)+":lock", c.opts.lockTTL, nil)
+		if err == nil {
+			defer func() {
+				_ = lock.Release(context.Background())
+			}()
+			// 拿到锁时其他实例可能刚刚写入了缓存
+			if stale == nil {
+				if e, err := c.getEntry(ctx, key); err == nil {
+					return e.Value, nil
+				}
+			}
+		} else if errors.Is(err, redislock.ErrNotObtained) {
+			// 其他实例正在刷新，直接使用旧数据
+			if stale != nil {
+				return stale.Value, nil
+			}
+			if e, ok := c.waitEntry(ctx, key); ok {
+				return e.Value, nil
+			}
+		} else {
+			zap.L().Warn("获取缓存锁失败", zap.String("cache", c.name), zap.String("key", key), zap.Error(err))
+		}
+	}
+
+	start := time.Now()
+	value, err = loader(ctx)
+	if err != nil {
+		return value, err
+	}
+	e := c.newEntry(value, expiration, time.Since(start))
+	if err := c.setEntry(ctx, key, e, expiration); err != nil {
+		zap.L().Warn("写入缓存失败", zap.String("cache", c.name), zap.String("key", key), zap.Error(err))
+	}
+	return value, nil
+}
+
+// waitEntry 等待持有锁的实例写入缓存，超时后返回false
+func (c *Cache[T]) waitEntry(ctx context.Context, key string) (*entry[T], bool) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.lockTTL)
+	defer cancel()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-ticker.C:
+			if e, err := c.getEntry(ctx, key); err == nil {
+				return e, true
+			}
+		}
+	}
+}
+
+// MGet 批量获取缓存，未命中的key不会出现在返回值中
+func (c *Cache[T]) MGet(ctx context.Context, keys ...string) (map[string]T, error) {
+	c.init()
+	ret := make(map[string]T, len(keys))
+	var missed []string
+	for _, key := range keys {
+		if c.local != nil {
+			if e, ok := c.local.get(key); ok {
+				ret[key] = e.Value
+				continue
+			}
+		}
+		missed = append(missed, key)
+	}
+
+	if len(missed) == 0 || c.store.client == nil {
+		return ret, nil
+	}
+
+	redisKeys := make([]string, 0, len(missed))
+	for _, key := range missed {
+		redisKeys = append(redisKeys, c.Key(key))
+	}
+	values, err := c.store.client.MGet(ctx, redisKeys...).Result()
+	if err != nil {
+		return ret, errors.WithStack(err)
+	}
+	for i, val := range values {
+		body, ok := val.(string)
+		if !ok {
+			continue
+		}
+		e, err := serialize.JsonParse[entry[T]](body)
+		if err != nil {
+			return ret, errors.WithStack(err)
+		}
+		ret[missed[i]] = e.Value
+		c.setLocal(missed[i], e)
+	}
+	return ret, nil
+}
+
+// MSet 批量设置缓存，所有key使用同一个过期时间
+func (c *Cache[T]) MSet(ctx context.Context, values map[string]T, ttl ...time.Duration) error {
+	c.init()
+	if len(values) == 0 {
+		return nil
+	}
+	expiration := c.expiration(ttl)
+	entries := make(map[string]*entry[T], len(values))
+	for key, value := range values {
+		entries[key] = c.newEntry(value, expiration, 0)
+	}
+	if c.store.client != nil {
+		_, err := c.store.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for key, e := range entries {
+				body, err := serialize.JsonStringifyBytes(e)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+				pipe.Set(ctx, c.Key(key), body, expiration)
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	keys := make([]string, 0, len(entries))
+	for key, e := range entries {
+		c.setLocal(key, e)
+		keys = append(keys, key)
+	}
+	return c.store.publish(ctx, c.Keys(keys...))
+}
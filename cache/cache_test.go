@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"context"
+	"github.com/vuuvv/orca/serialize"
+	"github.com/vuuvv/orca/utils"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLru(t *testing.T) {
+	l := newLru[int](2)
+	l.set("a", 1, 0)
+	l.set("b", 2, 0)
+	if _, ok := l.get("a"); !ok {
+		t.Fatal("expected a")
+	}
+	// b最久未使用，被淘汰
+	l.set("c", 3, 0)
+	if _, ok := l.get("b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+	if v, ok := l.get("c"); !ok || v != 3 {
+		t.Fatalf("expected 3, got %v", v)
+	}
+
+	l.set("d", 4, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := l.get("d"); ok {
+		t.Fatal("expected d to be expired")
+	}
+}
+
+type testUser struct {
+	Id   int64
+	Name string
+}
+
+func TestLocalCache(t *testing.T) {
+	ctx := context.Background()
+	c := New[*testUser]("user", WithStore(NewStore(nil, nil)))
+
+	_, err := c.Get(ctx, "1")
+	if !utils.RecordNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	loads := 0
+	loader := func(ctx context.Context) (*testUser, error) {
+		loads++
+		return &testUser{Id: 1, Name: "a"}, nil
+	}
+	for i := 0; i < 3; i++ {
+		u, err := c.GetOrLoad(ctx, "1", loader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Name != "a" {
+			t.Fatalf("expected a, got %s", u.Name)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("expected 1 load, got %d", loads)
+	}
+
+	err = c.MSet(ctx, map[string]*testUser{"2": {Id: 2}, "3": {Id: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := c.MGet(ctx, "1", "2", "3", "4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 {
+		t.Fatalf("expected 3 values, got %d", len(values))
+	}
+
+	if err = c.Delete(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Get(ctx, "1"); !utils.RecordNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	if exp, got := "cache:user:1", c.Key("1"); exp != got {
+		t.Fatalf("expected %s, got %s", exp, got)
+	}
+}
+
+func TestGetOrLoadConcurrent(t *testing.T) {
+	ctx := context.Background()
+	c := New[int]("concurrent", WithStore(NewStore(nil, nil)))
+
+	var loads int32
+	loader := func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(20 * time.Millisecond)
+		return 42, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.GetOrLoad(ctx, "key", loader)
+			if err != nil {
+				t.Error(err)
+			}
+			if v != 42 {
+				t.Errorf("expected 42, got %d", v)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&loads); got != 1 {
+		t.Fatalf("expected 1 load, got %d", got)
+	}
+}
+
+func TestShouldRefresh(t *testing.T) {
+	c := New[int]("refresh", WithStore(NewStore(nil, nil)), WithEarlyRefresh(1))
+	c.init()
+
+	fresh := c.newEntry(1, time.Hour, time.Millisecond)
+	if c.shouldRefresh(fresh) {
+		t.Fatal("expected fresh entry not to be refreshed")
+	}
+	expiring := c.newEntry(1, 0, time.Hour)
+	if !c.shouldRefresh(expiring) {
+		t.Fatal("expected expiring entry to be refreshed")
+	}
+
+	disabled := New[int]("refresh", WithStore(NewStore(nil, nil)))
+	disabled.init()
+	if disabled.shouldRefresh(expiring) {
+		t.Fatal("expected early refresh to be disabled")
+	}
+}
+
+func TestInvalidationMessage(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(nil, nil)
+	c := New[int]("invalidation", WithStore(s))
+	_ = c.Set(ctx, "1", 1)
+	_ = c.Set(ctx, "2", 2)
+
+	// 自己发出的消息不处理
+	s.handle(serialize.MustJsonStringify(&invalidation{Source: s.id, Targets: []*Keys{c.Keys("1")}}))
+	if _, err := c.Get(ctx, "1"); err != nil {
+		t.Fatalf("expected 1 to be kept, got %v", err)
+	}
+
+	s.handle(serialize.MustJsonStringify(&invalidation{Source: "other", Targets: []*Keys{c.Keys("1")}}))
+	if _, err := c.Get(ctx, "1"); !utils.RecordNotFound(err) {
+		t.Fatalf("expected 1 to be evicted, got %v", err)
+	}
+	if _, err := c.Get(ctx, "2"); err != nil {
+		t.Fatalf("expected 2 to be kept, got %v", err)
+	}
+
+	s.handle(serialize.MustJsonStringify(&invalidation{Source: "other", Targets: []*Keys{c.Keys()}}))
+	if _, err := c.Get(ctx, "2"); !utils.RecordNotFound(err) {
+		t.Fatalf("expected 2 to be evicted, got %v", err)
+	}
+}
@@ -0,0 +1,14 @@
+package cache
+
+import "time"
+
+type Config struct {
+	// Prefix redis key的前缀，默认为"cache"
+	Prefix string `json:"prefix"`
+	// TTL 默认过期时间，默认为10分钟
+	TTL time.Duration `json:"ttl"`
+	// LocalSize 进程内LRU缓存的容量，小于0表示不启用进程内缓存，默认为1000
+	LocalSize int `json:"localSize"`
+	// LocalTTL 进程内缓存的过期时间，不会超过TTL，默认为1分钟
+	LocalTTL time.Duration `json:"localTTL"`
+}
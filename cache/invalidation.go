@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/orca/serialize"
+	"go.uber.org/zap"
+)
+
+// Keys 一个缓存中需要失效的key，Keys为空表示清空整个缓存
+type Keys struct {
+	Cache string   `json:"cache"`
+	Keys  []string `json:"keys"`
+}
+
+// invalidation 通过redis pub/sub广播的失效消息
+type invalidation struct {
+	Source  string  `json:"source"`
+	Targets []*Keys `json:"targets"`
+}
+
+// localCache 注册到Store上的进程内缓存，用于接收失效消息
+type localCache interface {
+	Name() string
+	evictLocal(keys ...string)
+}
+
+func newInstanceId() string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (s *Store) channel() string {
+	return s.config.Prefix + ":invalidation"
+}
+
+func (s *Store) key(name string, key string) string {
+	return s.config.Prefix + ":" + name + ":" + key
+}
+
+func (s *Store) register(c localCache) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.caches[c.Name()] = append(s.caches[c.Name()], c)
+}
+
+func (s *Store) evictLocal(targets ...*Keys) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range targets {
+		for _, c := range s.caches[t.Cache] {
+			c.evictLocal(t.Keys...)
+		}
+	}
+}
+
+// Invalidate 使缓存失效：删除本进程的缓存和redis中的缓存，并通知其他实例删除其进程内缓存。
+// Keys为空时只清空各实例的进程内缓存
+func (s *Store) Invalidate(ctx context.Context, targets ...*Keys) error {
+	if len(targets) == 0 {
+		return nil
+	}
+	s.evictLocal(targets...)
+	if s.client == nil {
+		return nil
+	}
+
+	var redisKeys []string
+	for _, t := range targets {
+		for _, key := range t.Keys {
+			redisKeys = append(redisKeys, s.key(t.Cache, key))
+		}
+	}
+	if len(redisKeys) > 0 {
+		err := s.client.Del(ctx, redisKeys...).Err()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return s.publish(ctx, targets...)
+}
+
+// publish 通知其他实例删除进程内缓存
+func (s *Store) publish(ctx context.Context, targets ...*Keys) error {
+	if s.client == nil {
+		return nil
+	}
+	body, err := serialize.JsonStringify(&invalidation{Source: s.id, Targets: targets})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(s.client.Publish(ctx, s.channel(), body).Err())
+}
+
+// Subscribe 订阅其他实例的失效消息并删除对应的进程内缓存，阻塞直到ctx结束
+func (s *Store) Subscribe(ctx context.Context) error {
+	if s.client == nil {
+		return nil
+	}
+
+	pubsub := s.client.Subscribe(ctx, s.channel())
+	defer func() {
+		_ = pubsub.Close()
+	}()
+
+	_, err := pubsub.Receive(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	zap.L().Info("订阅缓存失效消息", zap.String("channel", s.channel()))
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			s.handle(msg.Payload)
+		}
+	}
+}
+
+func (s *Store) handle(payload string) {
+	msg, err := serialize.JsonParse[invalidation](payload)
+	if err != nil {
+		zap.L().Error("解析缓存失效消息失败", zap.String("payload", payload), zap.Error(err))
+		return
+	}
+	if msg.Source == s.id {
+		return
+	}
+	s.evictLocal(msg.Targets...)
+}
+
+// Invalidate 使用全局Store使缓存失效
+func Invalidate(ctx context.Context, targets ...*Keys) error {
+	if store == nil {
+		return nil
+	}
+	return store.Invalidate(ctx, targets...)
+}
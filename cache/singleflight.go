@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"github.com/vuuvv/errors"
+	"sync"
+)
+
+type call[T any] struct {
+	wg  sync.WaitGroup
+	val T
+	err error
+}
+
+// flight 合并同一个key的并发调用，同一时刻只有一个调用真正执行
+type flight[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[T]
+}
+
+func (g *flight[T]) do(key string, fn func() (T, error)) (T, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call[T])
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+	c := &call[T]{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			c.err = errors.Errorf("缓存加载异常: %v", r)
+			g.finish(key, c)
+			panic(r)
+		}
+	}()
+
+	c.val, c.err = fn()
+	g.finish(key, c)
+	return c.val, c.err
+}
+
+func (g *flight[T]) finish(key string, c *call[T]) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	c.wg.Done()
+}
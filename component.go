@@ -0,0 +1,198 @@
+package orca
+
+import (
+	"context"
+	"github.com/vuuvv/errors"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+)
+
+// Component 可插拔的应用组件，例如redis客户端、数据库、对象存储客户端。
+// 应用按依赖顺序初始化组件，关闭时按CloseOrder和依赖的逆序关闭
+type Component struct {
+	// Name 组件名称，同名组件后注册的覆盖先注册的
+	Name string
+	// ConfigKey 组件的配置key，为空时总是初始化，否则只有配置了该key时才初始化
+	ConfigKey string
+	// DependsOn 依赖的组件，依赖的组件未配置时不影响本组件初始化
+	DependsOn []string
+	// Retry 初始化失败时是否按启动重试策略重试，一般用于需要连接外部服务的组件
+	Retry bool
+	// Init 初始化组件，返回的值可通过Application.Component获取。失败时返回了值的，会先调用Close再重试
+	Init func(app *Application) (interface{}, error)
+	// Close 关闭组件，可为空
+	Close func(ctx context.Context, value interface{}) error
+	// CloseOrder 关闭顺序，默认为OrderData
+	CloseOrder int
+}
+
+var (
+	componentsMu sync.Mutex
+	components   []*Component
+)
+
+// RegisterComponent 注册全局组件，之后创建的应用都会初始化该组件
+func RegisterComponent(c *Component) {
+	componentsMu.Lock()
+	defer componentsMu.Unlock()
+	components = append(components, c)
+}
+
+// WithComponent 只为当前应用注册组件
+func WithComponent(c *Component) ApplicationOption {
+	return func(app *Application) {
+		app.extraComponents = append(app.extraComponents, c)
+	}
+}
+
+type initializedComponent struct {
+	component *Component
+	value     interface{}
+}
+
+// sortComponents 按依赖排序，没有依赖关系的组件保持注册顺序
+func sortComponents(list []*Component) ([]*Component, error) {
+	byName := make(map[string]*Component, len(list))
+	var names []string
+	for _, c := range list {
+		if _, ok := byName[c.Name]; !ok {
+			names = append(names, c.Name)
+		}
+		byName[c.Name] = c
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(byName))
+	ret := make([]*Component, 0, len(byName))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		c, ok := byName[name]
+		if !ok {
+			return errors.Errorf("组件[%s]依赖的组件[%s]未注册", path[len(path)-1], name)
+		}
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return errors.Errorf("组件存在循环依赖: %s", strings.Join(append(path, name), " -> "))
+		}
+		state[name] = visiting
+		for _, dep := range c.DependsOn {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		ret = append(ret, c)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func (this *Application) initComponents() error {
+	componentsMu.Lock()
+	list := append(builtinComponents(), components...)
+	componentsMu.Unlock()
+	list = append(list, this.extraComponents...)
+
+	sorted, err := sortComponents(list)
+	if err != nil {
+		return startupError("component", err)
+	}
+
+	this.components = make(map[string]interface{}, len(sorted))
+	for _, c := range sorted {
+		if c.ConfigKey != "" && !this.configLoader.IsSet(c.ConfigKey) {
+			continue
+		}
+		var value interface{}
+		init := func() (err error) {
+			value, err = c.Init(this)
+			if err != nil && value != nil && c.Close != nil {
+				// 释放失败时已经创建的连接，避免每次重试泄露连接池
+				if e := c.Close(context.Background(), value); e != nil {
+					zap.L().Error("关闭初始化失败的组件失败", zap.String("component", c.Name), zap.Error(e))
+				}
+			}
+			return err
+		}
+		if c.Retry {
+			err = this.config.Retry.retry(c.Name, init)
+		} else {
+			err = init()
+		}
+		if err == nil {
+			if err = this.bindBuiltin(c.Name, value); err != nil && c.Close != nil {
+				_ = c.Close(context.Background(), value)
+			}
+		}
+		if err != nil {
+			return startupError(c.Name, err)
+		}
+		this.components[c.Name] = value
+		this.initialized = append(this.initialized, &initializedComponent{component: c, value: value})
+		zap.L().Debug("组件初始化成功", zap.String("component", c.Name))
+	}
+	return nil
+}
+
+// registerComponentStopHooks 按依赖的逆序注册组件的关闭钩子
+func (this *Application) registerComponentStopHooks() {
+	for i := len(this.initialized) - 1; i >= 0; i-- {
+		ic := this.initialized[i]
+		if ic.component.Close == nil {
+			continue
+		}
+		order := ic.component.CloseOrder
+		if order == 0 {
+			order = OrderData
+		}
+		this.OnStop(ic.component.Name, order, func(ctx context.Context) error {
+			return ic.component.Close(ctx, ic.value)
+		})
+	}
+}
+
+// closeComponents 按依赖的逆序关闭已经初始化的组件，用于初始化失败时释放资源
+func (this *Application) closeComponents(ctx context.Context) {
+	for i := len(this.initialized) - 1; i >= 0; i-- {
+		ic := this.initialized[i]
+		if ic.component.Close == nil {
+			continue
+		}
+		if err := ic.component.Close(ctx, ic.value); err != nil {
+			zap.L().Error("关闭组件失败", zap.String("component", ic.component.Name), zap.Error(err))
+		}
+	}
+}
+
+// Component 获取已经初始化的组件，组件未注册或未配置时返回nil
+func (this *Application) Component(name string) interface{} {
+	return this.components[name]
+}
+
+// ComponentOf 获取指定类型的组件
+func ComponentOf[T any](app *Application, name string) (T, bool) {
+	value, ok := app.Component(name).(T)
+	return value, ok
+}
+
+// GetComponent 从默认应用获取指定类型的组件，组件不存在或类型不符时panic
+func GetComponent[T any](name string) T {
+	value, ok := ComponentOf[T](defaultApplication, name)
+	if !ok {
+		panic("组件[" + name + "]不存在")
+	}
+	return value
+}
@@ -0,0 +1,182 @@
+package orca
+
+import (
+	"context"
+	"errors"
+	"github.com/vuuvv/orca/orm"
+	"gorm.io/gorm"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSortComponents(t *testing.T) {
+	sorted, err := sortComponents([]*Component{
+		{Name: "a", DependsOn: []string{"c"}},
+		{Name: "b"},
+		{Name: "c", DependsOn: []string{"b"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range sorted {
+		names = append(names, c.Name)
+	}
+	if exp, got := "b,c,a", strings.Join(names, ","); exp != got {
+		t.Fatalf("expected %s, got %s", exp, got)
+	}
+
+	_, err = sortComponents([]*Component{
+		{Name: "a", DependsOn: []string{"b"}},
+		{Name: "b", DependsOn: []string{"a"}},
+	})
+	if err == nil {
+		t.Fatal("expected cycle error")
+	}
+
+	_, err = sortComponents([]*Component{
+		{Name: "a", DependsOn: []string{"missing"}},
+	})
+	if err == nil {
+		t.Fatal("expected missing dependency error")
+	}
+}
+
+type testStorage struct {
+	Bucket string
+	closed bool
+}
+
+func TestComponentLifecycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "application.yaml")
+	err := os.WriteFile(path, []byte("storage:\n  bucket: files\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := NewApplicationE(
+		WithConfigPath(path),
+		WithComponent(&Component{
+			Name:      "storage",
+			ConfigKey: "storage",
+			DependsOn: []string{ComponentId},
+			Init: func(app *Application) (interface{}, error) {
+				s := &testStorage{}
+				return s, app.UnmarshalConfig(s, "storage")
+			},
+			Close: func(ctx context.Context, value interface{}) error {
+				value.(*testStorage).closed = true
+				return nil
+			},
+		}),
+		WithComponent(&Component{
+			Name:      "unused",
+			ConfigKey: "unused",
+			Init: func(app *Application) (interface{}, error) {
+				t.Fatal("unconfigured component should not be initialized")
+				return nil, nil
+			},
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, ok := ComponentOf[*testStorage](app, "storage")
+	if !ok || s.Bucket != "files" {
+		t.Fatalf("unexpected storage %+v", s)
+	}
+	if app.Component(ComponentRedis) != nil {
+		t.Fatal("redis is not configured")
+	}
+
+	if err = app.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.closed {
+		t.Fatal("expected storage to be closed")
+	}
+}
+
+func TestComponentRetryClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "application.yaml")
+	err := os.WriteFile(path, []byte("storage:\n  bucket: files\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var created []*testStorage
+	app, err := NewApplicationE(
+		WithConfigPath(path),
+		WithStartupRetry(3, time.Millisecond),
+		WithComponent(&Component{
+			Name:  "storage",
+			Retry: true,
+			Init: func(app *Application) (interface{}, error) {
+				s := &testStorage{}
+				created = append(created, s)
+				if len(created) < 2 {
+					return s, errors.New("connect failed")
+				}
+				return s, nil
+			},
+			Close: func(ctx context.Context, value interface{}) error {
+				value.(*testStorage).closed = true
+				return nil
+			},
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = app.Stop()
+	}()
+
+	if len(created) != 2 || !created[0].closed || created[1].closed {
+		t.Fatalf("expected the failed attempt to be closed, got %+v", created)
+	}
+}
+
+func TestReplaceBuiltinComponent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "application.yaml")
+	err := os.WriteFile(path, []byte("database:\n  type: sqlite\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var replaced *gorm.DB
+	app, err := NewApplicationE(WithConfigPath(path), WithComponent(&Component{
+		Name:      ComponentDatabase,
+		ConfigKey: "database",
+		Init: func(app *Application) (interface{}, error) {
+			replaced, err = orm.New(&orm.Config{Type: "sqlite"})
+			return replaced, err
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = app.Stop()
+		_ = closeDatabase(replaced)
+	}()
+	if app.db == nil || app.db != replaced {
+		t.Fatal("expected the replacement database to be used")
+	}
+
+	_, err = NewApplicationE(WithConfigPath(path), WithComponent(&Component{
+		Name:      ComponentDatabase,
+		ConfigKey: "database",
+		Init: func(app *Application) (interface{}, error) {
+			return "not a database", nil
+		},
+	}))
+	var startupErr *StartupError
+	if !errors.As(err, &startupErr) || startupErr.Component != ComponentDatabase {
+		t.Fatalf("expected database startup error, got %v", err)
+	}
+}
@@ -0,0 +1,19 @@
+package orca
+
+import "time"
+
+const (
+	ModeHttp   = "http"
+	ModeWorker = "worker"
+)
+
+type Config struct {
+	// ShutdownTimeout 关闭应用的最长时间，默认5秒
+	ShutdownTimeout time.Duration `json:"shutdownTimeout"`
+	// Mode 运行模式，http(默认)或worker，worker模式不启动http服务，只运行后台任务
+	Mode string `json:"mode"`
+	// HealthAddr worker模式下健康检测服务的监听地址，例如":4001"，为空时不启动
+	HealthAddr string `json:"healthAddr"`
+	// Retry 启动时连接redis、数据库的重试策略
+	Retry RetryConfig `json:"retry"`
+}
@@ -37,6 +37,7 @@ const (
 
 type Generator struct {
 	ctx       context.Context
+	cancel    context.CancelFunc
 	snowflake *snowflake.Snowflake
 	client    *redis.Client
 	ttl       time.Duration
@@ -52,8 +53,10 @@ func NewGenerator(opts ...Option) (g *Generator, err error) {
 		return nil, errors.WithStack(err)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	g = &Generator{
-		ctx:       context.Background(),
+		ctx:       ctx,
+		cancel:    cancel,
 		ttl:       time.Second * 12,
 		keyFormat: "/snowflake/worker/%d",
 		uid:       uid,
@@ -79,6 +82,10 @@ func NewGenerator(opts ...Option) (g *Generator, err error) {
 		return nil, err
 	}
 
+	if g.client != nil {
+		g.keepalive()
+	}
+
 	if generator == nil {
 		ReplaceGlobal(g)
 	}
@@ -94,6 +101,18 @@ func (g *Generator) Next() (id int64) {
 	return g.snowflake.Next()
 }
 
+// NextN 批量生成n个id，生成器未运行时返回错误
+func (g *Generator) NextN(n int) ([]int64, error) {
+	if g.status != Running {
+		return nil, errors.New("id生成器未运行")
+	}
+	ids := make([]int64, n)
+	for i := range ids {
+		ids[i] = g.snowflake.Next()
+	}
+	return ids, nil
+}
+
 func (g *Generator) registerWorkerId() (err error) {
 	ctx := context.Background()
 
@@ -127,13 +146,33 @@ func (g *Generator) registerWorkerId() (err error) {
 func (g *Generator) keepalive() {
 	ticker := time.NewTicker(g.ttl - time.Second*2)
 	go func() {
-		for range ticker.C {
-			g.tick()
-			g.logErr()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-g.ctx.Done():
+				return
+			case <-ticker.C:
+				g.tick()
+				g.logErr()
+			}
 		}
 	}()
 }
 
+var luaRelease = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`)
+
+// Close 停止续期并释放占用的worker id，之后Next返回0
+func (g *Generator) Close(ctx context.Context) error {
+	g.cancel()
+	if g.client == nil || g.status != Running {
+		g.status = Stopped
+		return nil
+	}
+	g.status = Stopped
+	key := fmt.Sprintf(g.keyFormat, g.snowflake.GetWorkerId())
+	return errors.WithStack(luaRelease.Run(ctx, g.client, []string{key}, g.uid).Err())
+}
+
 func (g *Generator) tick() {
 	defer func() {
 		if r := recover(); r != nil {
@@ -198,3 +237,11 @@ func Next() (id int64) {
 	}
 	return generator.Next()
 }
+
+// NextN 使用全局生成器批量生成n个id
+func NextN(n int) ([]int64, error) {
+	if generator == nil {
+		panic(fmt.Sprintf("No ID generator set up"))
+	}
+	return generator.NextN(n)
+}
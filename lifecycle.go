@@ -0,0 +1,131 @@
+package orca
+
+import (
+	"context"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/orca/server"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// 钩子的执行顺序，数值小的先执行。关闭时依次：
+// 停止http服务，停止后台任务，释放雪花算法的worker id，关闭redis和数据库连接
+const (
+	OrderHttp   = 100
+	OrderWorker = 200
+	OrderId     = 300
+	OrderData   = 400
+)
+
+const defaultShutdownTimeout = 5 * time.Second
+
+type HookFunc func(ctx context.Context) error
+
+type hook struct {
+	name  string
+	order int
+	fn    HookFunc
+}
+
+func sortHooks(hooks []*hook) []*hook {
+	ret := make([]*hook, len(hooks))
+	copy(ret, hooks)
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].order < ret[j].order
+	})
+	return ret
+}
+
+// OnStart 添加启动钩子，在http服务启动前按order执行，出错时应用不会启动
+func (this *Application) OnStart(name string, order int, fn HookFunc) *Application {
+	this.startHooks = append(this.startHooks, &hook{name: name, order: order, fn: fn})
+	return this
+}
+
+// OnStop 添加关闭钩子，应用关闭时按order执行，order参考OrderHttp、OrderWorker、OrderId和OrderData
+func (this *Application) OnStop(name string, order int, fn HookFunc) *Application {
+	this.stopHooks = append(this.stopHooks, &hook{name: name, order: order, fn: fn})
+	return this
+}
+
+// Context 应用的根context，在OrderWorker阶段被取消，后台任务应在其结束时退出
+func (this *Application) Context() context.Context {
+	return this.ctx
+}
+
+func (this *Application) runStartHooks() error {
+	ctx := this.ctx
+	for _, h := range sortHooks(this.startHooks) {
+		zap.L().Info("执行启动钩子", zap.String("hook", h.name))
+		if err := h.fn(ctx); err != nil {
+			return errors.Wrapf(err, "启动钩子[%s]执行失败: %v", h.name, err)
+		}
+	}
+	return nil
+}
+
+// Stop 按顺序执行关闭钩子，所有钩子共享ShutdownTimeout，某个钩子出错不影响后续钩子执行。
+// 多次调用只执行一次，返回第一个错误
+func (this *Application) Stop() (err error) {
+	this.stopOnce.Do(func() {
+		timeout := this.config.ShutdownTimeout
+		if timeout <= 0 {
+			timeout = defaultShutdownTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		zap.L().Info("关闭应用", zap.Duration("timeout", timeout))
+		for _, h := range sortHooks(this.stopHooks) {
+			if e := h.fn(ctx); e != nil {
+				zap.L().Error("关闭钩子执行失败", zap.String("hook", h.name), zap.Error(e))
+				if err == nil {
+					err = errors.Wrapf(e, "关闭钩子[%s]执行失败: %v", h.name, e)
+				}
+			}
+		}
+		zap.L().Info("应用已关闭")
+		this.stopErr = err
+	})
+	return this.stopErr
+}
+
+func waitSignal() os.Signal {
+	// kill (no param) default send syscall.SIGTERM
+	// kill -2 is syscall.SIGINT
+	// kill -9 is syscall.SIGKILL but can't be caught, so don't need to add it
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(quit)
+	return <-quit
+}
+
+func (this *Application) registerStopHooks() {
+	if graceful, ok := this.httpServer.(server.GracefulServer); ok {
+		this.OnStop("http", OrderHttp, graceful.Shutdown)
+	}
+
+	if this.healthServer != nil {
+		this.OnStop("health", OrderHttp, this.healthServer.Shutdown)
+	}
+
+	this.OnStop("context", OrderWorker, func(ctx context.Context) error {
+		this.cancel()
+		return nil
+	})
+	this.OnStop("workers", OrderWorker, this.waitWorkers)
+
+	this.registerComponentStopHooks()
+}
+
+// release 初始化失败时关闭已经初始化的组件
+func (this *Application) release() {
+	this.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+	defer cancel()
+	this.closeComponents(ctx)
+}
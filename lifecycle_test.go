@@ -0,0 +1,43 @@
+package orca
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestStopOrder(t *testing.T) {
+	app := &Application{config: &Config{}}
+	app.ctx, app.cancel = context.WithCancel(context.Background())
+
+	var calls []string
+	record := func(name string, err error) HookFunc {
+		return func(ctx context.Context) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+	app.OnStop("redis", OrderData, record("redis", nil))
+	app.OnStop("http", OrderHttp, record("http", errors.New("http")))
+	app.OnStop("id", OrderId, record("id", nil))
+	app.OnStop("database", OrderData, record("database", nil))
+	app.registerStopHooks()
+
+	err := app.Stop()
+	if err == nil {
+		t.Fatal("expected error from http hook")
+	}
+	if exp := []string{"http", "id", "redis", "database"}; !reflect.DeepEqual(exp, calls) {
+		t.Fatalf("expected %v, got %v", exp, calls)
+	}
+	if app.Context().Err() == nil {
+		t.Fatal("expected context to be cancelled")
+	}
+
+	// 只执行一次
+	_ = app.Stop()
+	if len(calls) != 4 {
+		t.Fatalf("expected hooks to run once, got %v", calls)
+	}
+}
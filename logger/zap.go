@@ -1,7 +1,7 @@
 package logger
 
 import (
-	"fmt"
+	"github.com/vuuvv/errors"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"os"
@@ -18,8 +18,17 @@ var levelMap = map[string]zapcore.Level{
 }
 
 func NewLogger(config *Config, opts ...zap.Option) *zap.Logger {
+	ret, err := New(config, opts...)
+	if err != nil {
+		panic(err.Error())
+	}
+	return ret
+}
+
+// New 创建logger并替换zap的全局logger，配置错误时返回错误
+func New(config *Config, opts ...zap.Option) (*zap.Logger, error) {
 	if config == nil {
-		panic("Initialize logger error: config is nil")
+		return nil, errors.New("Initialize logger error: config is nil")
 	}
 
 	if config.Level == "" {
@@ -40,7 +49,7 @@ func NewLogger(config *Config, opts ...zap.Option) *zap.Logger {
 
 	level, ok := levelMap[config.Level]
 	if !ok {
-		panic(fmt.Sprintf("Initialize logger error: wrong logger level [%s]", config.Level))
+		return nil, errors.Errorf("Initialize logger error: wrong logger level [%s]", config.Level)
 	}
 
 	encConfig := zapcore.EncoderConfig{
@@ -72,8 +81,8 @@ func NewLogger(config *Config, opts ...zap.Option) *zap.Logger {
 
 	ret, err := zapConfig.Build(opts...)
 	if err != nil {
-		panic(fmt.Sprintf("Initialize logger error: %v", err))
+		return nil, errors.Wrapf(err, "Initialize logger error: %v", err)
 	}
 	zap.ReplaceGlobals(ret)
-	return ret
+	return ret, nil
 }
@@ -0,0 +1,32 @@
+package migrate
+
+import (
+	"github.com/vuuvv/orca/orm"
+	"gorm.io/gorm"
+)
+
+// builtinMigrations orca自身需要的表，版本号小于业务迁移使用的时间戳，总是先执行
+func builtinMigrations() []*Migration {
+	return []*Migration{
+		{
+			Version: 1,
+			Name:    "create_sequence",
+			Up: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&orm.Sequence{})
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&orm.Sequence{})
+			},
+		},
+		{
+			Version: 2,
+			Name:    "create_change_log",
+			Up: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&orm.ChangeLog{})
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(&orm.ChangeLog{})
+			},
+		},
+	}
+}
@@ -0,0 +1,14 @@
+package migrate
+
+import "time"
+
+type Config struct {
+	// Auto 应用启动时自动执行未执行的迁移
+	Auto bool
+	// Table 记录已执行迁移的表，默认t_migration
+	Table string
+	// LockKey 多个实例同时启动时，只有获得该redis锁的实例执行迁移，默认migrate:lock
+	LockKey string
+	// LockTtl 迁移锁的过期时间，也是等待其他实例迁移完成的最长时间，默认10分钟。迁移期间每隔LockTtl/3续期
+	LockTtl time.Duration
+}
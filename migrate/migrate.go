@@ -0,0 +1,305 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/orca/redislock"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Migration 一个版本的迁移，按Version从小到大执行，Version一般使用时间戳，例如20220301120000
+type Migration struct {
+	Version int64
+	Name    string
+	// Up 执行迁移，在事务中执行
+	Up func(tx *gorm.DB) error
+	// Down 回滚迁移，可为空，为空时不可回滚
+	Down func(tx *gorm.DB) error
+}
+
+// record 迁移记录
+type record struct {
+	Version   int64     `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"size:255"`
+	AppliedAt time.Time `gorm:"comment:执行时间"`
+}
+
+// Status 迁移的执行状态
+type Status struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []*Migration
+)
+
+// Register 注册全局迁移，New未指定迁移时使用
+func Register(migrations ...*Migration) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, migrations...)
+}
+
+// Registered 返回orca自带的迁移和所有注册的迁移
+func Registered() []*Migration {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	ret := builtinMigrations()
+	return append(ret, registry...)
+}
+
+type Option func(m *Migrator)
+
+// WithRedisClient 使用redis锁保证只有一个实例执行迁移，不设置时不加锁
+func WithRedisClient(client *redis.Client) Option {
+	return func(m *Migrator) {
+		m.client = client
+	}
+}
+
+// WithMigrations 使用指定的迁移代替全局注册的迁移
+func WithMigrations(migrations ...*Migration) Option {
+	return func(m *Migrator) {
+		m.migrations = migrations
+	}
+}
+
+type Migrator struct {
+	db         *gorm.DB
+	client     *redis.Client
+	config     *Config
+	migrations []*Migration
+}
+
+func New(db *gorm.DB, config *Config, opts ...Option) (*Migrator, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	if config.Table == "" {
+		config.Table = "t_migration"
+	}
+	if config.LockKey == "" {
+		config.LockKey = "migrate:lock"
+	}
+	if config.LockTtl <= 0 {
+		config.LockTtl = 10 * time.Minute
+	}
+
+	m := &Migrator{db: db, config: config}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.migrations == nil {
+		m.migrations = Registered()
+	}
+
+	sorted := make([]*Migration, len(m.migrations))
+	copy(sorted, m.migrations)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	for i, item := range sorted {
+		if item.Up == nil {
+			return nil, errors.Errorf("迁移[%d_%s]未指定Up", item.Version, item.Name)
+		}
+		if i > 0 && sorted[i-1].Version == item.Version {
+			return nil, errors.Errorf("迁移版本重复: %d", item.Version)
+		}
+	}
+	m.migrations = sorted
+	return m, nil
+}
+
+func (m *Migrator) table(db *gorm.DB) *gorm.DB {
+	return db.Table(m.config.Table)
+}
+
+func (m *Migrator) ensureTable(db *gorm.DB) error {
+	return errors.WithStack(m.table(db).AutoMigrate(&record{}))
+}
+
+func (m *Migrator) applied(db *gorm.DB) (map[int64]*record, error) {
+	var records []*record
+	err := m.table(db).Order("version").Find(&records).Error
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	ret := make(map[int64]*record, len(records))
+	for _, r := range records {
+		ret[r.Version] = r
+	}
+	return ret, nil
+}
+
+// lock 获取迁移锁，未设置redis时不加锁。持有锁期间定时续期，续期失败时取消ctx，停止后续迁移
+func (m *Migrator) lock(ctx context.Context, fn func(ctx context.Context) error) error {
+	if m.client == nil {
+		return fn(ctx)
+	}
+	lock, err := redislock.New(m.client).Obtain(ctx, m.config.LockKey, m.config.LockTtl, &redislock.Options{
+		RetryStrategy: redislock.LinearBackoff(time.Second),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "获取迁移锁失败: %v", err)
+	}
+	defer func() {
+		_ = lock.Release(context.Background())
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	var refreshErr error
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(m.config.LockTtl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := lock.Refresh(ctx, m.config.LockTtl, nil); err != nil && ctx.Err() == nil {
+					refreshErr = err
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err = fn(ctx)
+	cancel()
+	<-done
+	if refreshErr != nil {
+		return errors.Wrapf(refreshErr, "迁移锁续期失败，已停止迁移: %v", refreshErr)
+	}
+	return err
+}
+
+// Up 按版本顺序执行所有未执行的迁移，每个迁移在单独的事务中执行，出错时停止
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.lock(ctx, func(ctx context.Context) error {
+		db := m.db.WithContext(ctx)
+		if err := m.ensureTable(db); err != nil {
+			return err
+		}
+		applied, err := m.applied(db)
+		if err != nil {
+			return err
+		}
+		for _, item := range m.migrations {
+			if _, ok := applied[item.Version]; ok {
+				continue
+			}
+			if err = ctx.Err(); err != nil {
+				return errors.WithStack(err)
+			}
+			err = db.Transaction(func(tx *gorm.DB) error {
+				if err := item.Up(tx); err != nil {
+					return err
+				}
+				return m.table(tx).Create(&record{Version: item.Version, Name: item.Name, AppliedAt: time.Now()}).Error
+			})
+			if err != nil {
+				return errors.Wrapf(err, "执行迁移[%d_%s]失败: %v", item.Version, item.Name, err)
+			}
+			zap.L().Info("执行迁移", zap.Int64("version", item.Version), zap.String("name", item.Name))
+		}
+		return nil
+	})
+}
+
+// Down 按版本倒序回滚最近执行的steps个迁移
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.lock(ctx, func(ctx context.Context) error {
+		db := m.db.WithContext(ctx)
+		if err := m.ensureTable(db); err != nil {
+			return err
+		}
+		applied, err := m.applied(db)
+		if err != nil {
+			return err
+		}
+		byVersion := make(map[int64]*Migration, len(m.migrations))
+		for _, item := range m.migrations {
+			byVersion[item.Version] = item
+		}
+		versions := make([]int64, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool {
+			return versions[i] > versions[j]
+		})
+
+		for i := 0; i < steps && i < len(versions); i++ {
+			r := applied[versions[i]]
+			item, ok := byVersion[r.Version]
+			if !ok {
+				return errors.Errorf("迁移[%d_%s]不存在，无法回滚", r.Version, r.Name)
+			}
+			if item.Down == nil {
+				return errors.Errorf("迁移[%d_%s]未指定Down，无法回滚", item.Version, item.Name)
+			}
+			err = db.Transaction(func(tx *gorm.DB) error {
+				if err := item.Down(tx); err != nil {
+					return err
+				}
+				return m.table(tx).Where("version = ?", item.Version).Delete(&record{}).Error
+			})
+			if err != nil {
+				return errors.Wrapf(err, "回滚迁移[%d_%s]失败: %v", item.Version, item.Name, err)
+			}
+			zap.L().Info("回滚迁移", zap.Int64("version", item.Version), zap.String("name", item.Name))
+		}
+		return nil
+	})
+}
+
+// Status 返回所有迁移的执行状态，包括已执行但未注册的迁移
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) {
+	db := m.db.WithContext(ctx)
+	if err := m.ensureTable(db); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*Status, 0, len(m.migrations))
+	for _, item := range m.migrations {
+		s := &Status{Version: item.Version, Name: item.Name}
+		if r, ok := applied[item.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = &r.AppliedAt
+			delete(applied, item.Version)
+		}
+		ret = append(ret, s)
+	}
+	for _, r := range applied {
+		ret = append(ret, &Status{Version: r.Version, Name: r.Name, Applied: true, AppliedAt: &r.AppliedAt})
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Version < ret[j].Version
+	})
+	return ret, nil
+}
+
+func (s *Status) String() string {
+	state := "pending"
+	if s.Applied {
+		state = "applied at " + s.AppliedAt.Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprintf("%d_%s: %s", s.Version, s.Name, state)
+}
@@ -0,0 +1,193 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/vuuvv/orca/orm"
+	"gorm.io/gorm"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := orm.New(&orm.Config{Type: "sqlite"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		_ = sqlDB.Close()
+	})
+	return db
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	fsys := fstest.MapFS{
+		"sql/20220302000000_create_role.up.sql":   {Data: []byte("create table t_role (id integer primary key, name text)")},
+		"sql/20220302000000_create_role.down.sql": {Data: []byte("drop table t_role")},
+		"sql/readme.md": {Data: []byte("ignored")},
+	}
+	sqlMigrations, err := FromFS(fsys, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sqlMigrations) != 1 {
+		t.Fatalf("expected 1 sql migration, got %d", len(sqlMigrations))
+	}
+
+	migrations := append(builtinMigrations(), sqlMigrations...)
+	migrations = append(migrations, &Migration{
+		Version: 20220301000000,
+		Name:    "create_user",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("create table t_user (id integer primary key)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("drop table t_user").Error
+		},
+	})
+	m, err := New(db, nil, WithMigrations(migrations...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// 重复执行不会再次迁移
+	if err = m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"t_sequence", "t_change_log", "t_user", "t_role"} {
+		if !db.Migrator().HasTable(table) {
+			t.Fatalf("expected table %s", table)
+		}
+	}
+
+	if err = m.Down(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if db.Migrator().HasTable("t_role") {
+		t.Fatal("expected t_role to be dropped")
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		version int64
+		applied bool
+	}{{1, true}, {2, true}, {20220301000000, true}, {20220302000000, false}}
+	if len(status) != len(expected) {
+		t.Fatalf("expected %d status, got %d", len(expected), len(status))
+	}
+	for i, e := range expected {
+		if status[i].Version != e.version || status[i].Applied != e.applied {
+			t.Fatalf("unexpected status: %s", status[i])
+		}
+	}
+}
+
+func TestDuplicateVersion(t *testing.T) {
+	up := func(tx *gorm.DB) error { return nil }
+	_, err := New(newTestDB(t), nil, WithMigrations(
+		&Migration{Version: 1, Name: "a", Up: up},
+		&Migration{Version: 1, Name: "b", Up: up},
+	))
+	if err == nil {
+		t.Fatal("expected duplicate version error")
+	}
+}
+
+// fakeLock 模拟redis锁的加锁、续期和释放，不连接redis
+type fakeLock struct {
+	mu        sync.Mutex
+	refreshes int
+	lost      bool
+}
+
+func (h *fakeLock) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, errors.New("redis disabled in test")
+}
+
+func (h *fakeLock) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cmd.SetErr(nil)
+	switch c := cmd.(type) {
+	case *redis.BoolCmd:
+		c.SetVal(true)
+	case *redis.Cmd:
+		// 续期脚本比释放脚本多一个ttl参数
+		if len(c.Args()) == 6 {
+			h.refreshes++
+			if h.lost {
+				c.SetVal(int64(0))
+				return nil
+			}
+		}
+		c.SetVal(int64(1))
+	}
+	return nil
+}
+
+func (h *fakeLock) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *fakeLock) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func TestMigratorLockRefresh(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	defer client.Close()
+	hook := &fakeLock{}
+	client.AddHook(hook)
+
+	slow := func(tx *gorm.DB) error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	}
+	config := &Config{LockTtl: 30 * time.Millisecond}
+	m, err := New(db, config, WithRedisClient(client), WithMigrations(&Migration{Version: 1, Name: "slow", Up: slow}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if hook.refreshes == 0 {
+		t.Fatal("expected the lock to be refreshed during migration")
+	}
+
+	// 锁被其他实例取得后停止迁移
+	hook.lost = true
+	m, err = New(db, config, WithRedisClient(client), WithMigrations(
+		&Migration{Version: 1, Name: "slow", Up: slow},
+		&Migration{Version: 2, Name: "lost", Up: slow},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Up(ctx); err == nil {
+		t.Fatal("expected migration to stop after losing the lock")
+	}
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status[1].Applied {
+		t.Fatal("expected migration 2 to be rolled back")
+	}
+}
@@ -0,0 +1,82 @@
+package migrate
+
+import (
+	"github.com/vuuvv/errors"
+	"gorm.io/gorm"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// sqlFilePattern sql迁移文件名，例如20220301120000_create_user.up.sql和20220301120000_create_user.down.sql
+var sqlFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// FromFS 从目录中读取sql迁移，一个文件包含多条语句时需要数据库驱动支持，例如mysql需在dsn中设置multiStatements=true
+func FromFS(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		matches := sqlFilePattern.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = m
+		} else if m.Name != matches[2] {
+			return nil, errors.Errorf("迁移版本重复: %d", version)
+		}
+		if matches[3] == "up" {
+			m.Up = execSql(string(content))
+		} else {
+			m.Down = execSql(string(content))
+		}
+	}
+
+	ret := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == nil {
+			return nil, errors.Errorf("迁移[%d_%s]缺少up文件", m.Version, m.Name)
+		}
+		ret = append(ret, m)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Version < ret[j].Version
+	})
+	return ret, nil
+}
+
+// RegisterFS 注册目录中的sql迁移
+func RegisterFS(fsys fs.FS, dir string) error {
+	migrations, err := FromFS(fsys, dir)
+	if err != nil {
+		return err
+	}
+	Register(migrations...)
+	return nil
+}
+
+func execSql(sql string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		return tx.Exec(sql).Error
+	}
+}
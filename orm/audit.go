@@ -0,0 +1,96 @@
+package orm
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+const (
+	auditCreatedBy = "CreatedBy"
+	auditUpdatedBy = "UpdatedBy"
+)
+
+// auditPlugin 创建和更新时填充CreatedBy、UpdatedBy，当前用户通过CurrentUserId获取，
+// 获取不到时(例如后台任务)使用systemUserId
+type auditPlugin struct {
+	systemUserId int64
+}
+
+func (p *auditPlugin) Name() string {
+	return "orca:audit"
+}
+
+func (p *auditPlugin) Initialize(db *gorm.DB) error {
+	err := db.Callback().Create().Before("gorm:create").Register("orca:audit_create", p.beforeCreate)
+	if err != nil {
+		return err
+	}
+	return db.Callback().Update().Before("gorm:update").Register("orca:audit_update", p.beforeUpdate)
+}
+
+func (p *auditPlugin) userId(db *gorm.DB) int64 {
+	if id := CurrentUserId(db.Statement.Context); id != 0 {
+		return id
+	}
+	return p.systemUserId
+}
+
+// beforeCreate 只填充为空的字段，保留调用方指定的值
+func (p *auditPlugin) beforeCreate(db *gorm.DB) {
+	stmt := db.Statement
+	if stmt.Schema == nil || db.Error != nil {
+		return
+	}
+	createdBy := stmt.Schema.LookUpField(auditCreatedBy)
+	updatedBy := stmt.Schema.LookUpField(auditUpdatedBy)
+	if createdBy == nil && updatedBy == nil {
+		return
+	}
+	user := p.userId(db)
+	if user == 0 {
+		return
+	}
+
+	set := func(rv reflect.Value) {
+		for rv.Kind() == reflect.Ptr {
+			rv = rv.Elem()
+		}
+		for _, field := range []*schema.Field{createdBy, updatedBy} {
+			if field == nil {
+				continue
+			}
+			if _, zero := field.ValueOf(stmt.Context, rv); zero {
+				_ = field.Set(stmt.Context, rv, user)
+			}
+		}
+	}
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			set(stmt.ReflectValue.Index(i))
+		}
+	case reflect.Struct:
+		set(stmt.ReflectValue)
+	}
+}
+
+// beforeUpdate 更新时总是设置UpdatedBy，UpdateColumn(s)等跳过钩子的更新不处理
+func (p *auditPlugin) beforeUpdate(db *gorm.DB) {
+	stmt := db.Statement
+	if stmt.Schema == nil || stmt.SkipHooks || db.Error != nil {
+		return
+	}
+	field := stmt.Schema.LookUpField(auditUpdatedBy)
+	if field == nil {
+		return
+	}
+	user := p.userId(db)
+	if user == 0 {
+		return
+	}
+	stmt.SetColumn(field.DBName, user, true)
+	if len(stmt.Selects) > 0 && !contains(stmt.Selects, "*") && !contains(stmt.Selects, field.DBName) && !contains(stmt.Selects, field.Name) {
+		stmt.Selects = append(stmt.Selects, field.DBName)
+	}
+}
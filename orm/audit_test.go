@@ -0,0 +1,40 @@
+package orm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAuditPlugin(t *testing.T) {
+	db := newTestDBWithConfig(t, &Config{SystemUserId: 1}, &testUser{})
+
+	users := []*testUser{{Name: "a", Email: "a@test.com"}, {Name: "b", Email: "b@test.com"}}
+	err := db.Create(&users).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range users {
+		if u.CreatedBy != 1 || u.UpdatedBy != 1 {
+			t.Fatalf("expected system user, got %d %d", u.CreatedBy, u.UpdatedBy)
+		}
+	}
+
+	ctx := WithUserId(context.Background(), 9)
+	if err = db.WithContext(ctx).Model(users[0]).Update("name", "c").Error; err != nil {
+		t.Fatal(err)
+	}
+	err = db.WithContext(ctx).Model(users[1]).Select("Name").Updates(&testUser{Name: "d"}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []*testUser
+	if err = db.Order("name").Find(&got).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range got {
+		if u.CreatedBy != 1 || u.UpdatedBy != 9 {
+			t.Fatalf("unexpected audit fields of %s: %d %d", u.Name, u.CreatedBy, u.UpdatedBy)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package orm
+
+import (
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/orca/id"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+)
+
+const defaultBatchSize = 1000
+
+type batchOptions struct {
+	size          int
+	messages      map[string]string
+	updateColumns []string
+}
+
+type BatchOption func(opts *batchOptions)
+
+// WithBatchSize 每批的行数，默认为1000
+func WithBatchSize(size int) BatchOption {
+	return func(opts *batchOptions) {
+		opts.size = size
+	}
+}
+
+// WithDuplicateMessages 违反唯一约束时的提示，见DuplicateMessage
+func WithDuplicateMessages(messages map[string]string) BatchOption {
+	return func(opts *batchOptions) {
+		opts.messages = messages
+	}
+}
+
+// WithUpdateColumns Upsert冲突时更新的列，默认为冲突列、主键和创建信息以外的所有列，嵌入Versioned时版本号递增
+func WithUpdateColumns(columns ...string) BatchOption {
+	return func(opts *batchOptions) {
+		opts.updateColumns = columns
+	}
+}
+
+func newBatchOptions(opts []BatchOption) *batchOptions {
+	options := &batchOptions{size: defaultBatchSize}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.size <= 0 {
+		options.size = defaultBatchSize
+	}
+	return options
+}
+
+// assignIds 为id为0的记录批量生成id
+func assignIds[T EntityType](models []T) error {
+	var empty []T
+	for _, model := range models {
+		if model.GetId() == 0 {
+			empty = append(empty, model)
+		}
+	}
+	if len(empty) == 0 {
+		return nil
+	}
+	ids, err := id.NextN(len(empty))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for i, value := range ids {
+		empty[i].SetId(value)
+	}
+	return nil
+}
+
+// BulkInsert 分批插入，T为模型的指针类型，id为0的记录批量生成id，不插入关联
+func BulkInsert[T EntityType](db *gorm.DB, models []T, opts ...BatchOption) error {
+	if len(models) == 0 {
+		return nil
+	}
+	options := newBatchOptions(opts)
+	if err := assignIds(models); err != nil {
+		return err
+	}
+	err := db.Omit(clause.Associations).CreateInBatches(models, options.size).Error
+	if err != nil {
+		return DuplicateMessage(err, options.messages)
+	}
+	return invalidateCache(db)
+}
+
+// Upsert 分批插入，conflictColumns冲突时更新已有的记录。mysql使用ON DUPLICATE KEY UPDATE，
+// 忽略conflictColumns，任一唯一索引冲突都会更新。更新已有记录时models中生成的id不是数据库中的id
+func Upsert[T EntityType](db *gorm.DB, models []T, conflictColumns []string, opts ...BatchOption) error {
+	if len(models) == 0 {
+		return nil
+	}
+	if len(conflictColumns) == 0 {
+		return errors.New("未指定冲突字段")
+	}
+	options := newBatchOptions(opts)
+	updates := options.updateColumns
+	var version clause.Assignment
+	if len(updates) == 0 {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(models[0]); err != nil {
+			return errors.WithStack(err)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" || field.PrimaryKey || contains(conflictColumns, field.DBName) ||
+				field.Name == "CreatedBy" || field.Name == "CreatedAt" {
+				continue
+			}
+			if _, ok := EntityType(models[0]).(VersionedType); ok && field.Name == versionField {
+				// 更新已有记录时版本号递增，而不是使用新记录的版本号
+				column := Quote(db, field.DBName)
+				version = clause.Assignment{Column: clause.Column{Name: field.DBName}, Value: gorm.Expr(column + " + 1")}
+				continue
+			}
+			updates = append(updates, field.DBName)
+		}
+	}
+
+	columns := make([]clause.Column, 0, len(conflictColumns))
+	for _, column := range conflictColumns {
+		columns = append(columns, clause.Column{Name: column})
+	}
+	assignments := clause.AssignmentColumns(updates)
+	if version.Column.Name != "" {
+		assignments = append(assignments, version)
+	}
+	if err := assignIds(models); err != nil {
+		return err
+	}
+	err := db.Omit(clause.Associations).Clauses(clause.OnConflict{
+		Columns:   columns,
+		DoUpdates: assignments,
+	}).CreateInBatches(models, options.size).Error
+	if err != nil {
+		return DuplicateMessage(err, options.messages)
+	}
+	return invalidateCache(db)
+}
+
+// UpdateByIds 将ids对应的记录更新为相同的值，按批次执行，返回更新的行数。
+// 使用乐观锁的模型不检查版本号，但版本号递增，持有旧版本号的更新会冲突
+func UpdateByIds[T EntityType](db *gorm.DB, ids []int64, values map[string]interface{}, opts ...BatchOption) (int64, error) {
+	if len(ids) == 0 || len(values) == 0 {
+		return 0, nil
+	}
+	options := newBatchOptions(opts)
+	var zero T
+	model := reflect.New(reflect.TypeOf(zero).Elem()).Interface()
+	if _, ok := model.(VersionedType); ok {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return 0, errors.WithStack(err)
+		}
+		if field := stmt.Schema.LookUpField(versionField); field != nil {
+			_, byColumn := values[field.DBName]
+			_, byName := values[field.Name]
+			if !byColumn && !byName {
+				copied := make(map[string]interface{}, len(values)+1)
+				for k, v := range values {
+					copied[k] = v
+				}
+				copied[field.DBName] = gorm.Expr(Quote(db, field.DBName) + " + 1")
+				values = copied
+			}
+		}
+	}
+	var affected int64
+	for start := 0; start < len(ids); start += options.size {
+		end := start + options.size
+		if end > len(ids) {
+			end = len(ids)
+		}
+		result := db.Model(model).Where("id IN ?", ids[start:end]).Updates(values)
+		if result.Error != nil {
+			return affected, DuplicateMessage(result.Error, options.messages)
+		}
+		affected += result.RowsAffected
+	}
+	return affected, invalidateCache(db)
+}
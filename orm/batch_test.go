@@ -0,0 +1,90 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+	"github.com/vuuvv/orca/id"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	db := newTestDB(t, &testUser{}, &testMenu{})
+	ctx := WithUserId(context.Background(), 3)
+
+	users := make([]*testUser, 25)
+	for i := range users {
+		users[i] = &testUser{Name: fmt.Sprintf("u%d", i)}
+	}
+	if err := BulkInsert(db.WithContext(ctx), users, WithBatchSize(10)); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int64, 0, len(users))
+	seen := map[int64]bool{}
+	for _, u := range users {
+		if u.GetId() == 0 || seen[u.GetId()] || u.CreatedBy != 3 {
+			t.Fatalf("unexpected user: %+v", u)
+		}
+		seen[u.GetId()] = true
+		ids = append(ids, u.GetId())
+	}
+
+	affected, err := UpdateByIds[*testUser](db, ids[:12], map[string]interface{}{"email": "x@x.com"}, WithBatchSize(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&testUser{}).Where("email = ?", "x@x.com").Count(&count)
+	if affected != 12 || count != 12 {
+		t.Fatalf("expected 12 updated, got %d %d", affected, count)
+	}
+
+	menus := []*testMenu{{Name: "a"}, {Name: "a"}}
+	err = BulkInsert(db, menus, WithDuplicateMessages(map[string]string{"t_menu.name": "菜单名称重复"}))
+	if err == nil || err.Error() != "菜单名称重复" {
+		t.Fatalf("expected duplicate message, got %v", err)
+	}
+
+	first := &testMenu{Name: "a"}
+	first.Code = "1"
+	if err = BulkInsert(db, []*testMenu{first}); err != nil {
+		t.Fatal(err)
+	}
+	second := &testMenu{Name: "a"}
+	second.Code = "2"
+	if err = Upsert(db, []*testMenu{second, {Name: "b"}}, []string{"name"}); err != nil {
+		t.Fatal(err)
+	}
+	var got []*testMenu
+	db.Order("name").Find(&got)
+	if len(got) != 2 || got[0].GetId() != first.GetId() || got[0].Code != "2" || got[1].Name != "b" {
+		t.Fatalf("unexpected menus: %+v", got)
+	}
+}
+
+func TestBatchStoppedGenerator(t *testing.T) {
+	db := newTestDB(t, &testUser{})
+	g, err := id.NewGenerator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = g.Close(context.Background())
+	id.ReplaceGlobal(g)
+	defer func() {
+		if g, err := id.NewGenerator(); err == nil {
+			id.ReplaceGlobal(g)
+		}
+	}()
+
+	users := []*testUser{{Name: "a"}, {Name: "b"}}
+	if err = BulkInsert(db, users); err == nil {
+		t.Fatal("expected stopped generator error")
+	}
+	if err = Upsert(db, users, []string{"name"}); err == nil {
+		t.Fatal("expected stopped generator error")
+	}
+	var count int64
+	db.Model(&testUser{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("expected nothing inserted, got %d", count)
+	}
+}
@@ -0,0 +1,38 @@
+package orm
+
+import (
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/orca/cache"
+	"gorm.io/gorm"
+)
+
+const cacheInvalidateKey = "orca:cache_invalidate"
+
+// Invalidate 指定更新成功后需要失效的缓存，用于Update、Updates、DeleteTree，例如：
+//
+//	orm.Update(orm.Invalidate(tx, userCache.Keys(id)), model, form)
+//
+// 在RunTx或Transaction开启的事务中使用时，缓存在事务提交后失效，避免并发读取在提交前重新缓存旧的记录
+func Invalidate(db *gorm.DB, targets ...*cache.Keys) *gorm.DB {
+	return db.Set(cacheInvalidateKey, append(invalidateTargets(db), targets...)).Session(&gorm.Session{})
+}
+
+func invalidateTargets(db *gorm.DB) []*cache.Keys {
+	val, ok := db.Get(cacheInvalidateKey)
+	if !ok {
+		return nil
+	}
+	targets, _ := val.([]*cache.Keys)
+	return targets
+}
+
+func invalidateCache(db *gorm.DB) error {
+	targets := invalidateTargets(db)
+	if len(targets) == 0 {
+		return nil
+	}
+	ctx := db.Statement.Context
+	return AfterCommit(db, func() error {
+		return errors.WithStack(cache.Invalidate(ctx, targets...))
+	})
+}
@@ -0,0 +1,444 @@
+package orm
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/orca/orm/datatypes"
+	"github.com/vuuvv/orca/serialize"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"time"
+)
+
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+const (
+	changeLogPluginName = "orca:change_log"
+	changeLogOldRowsKey = "orca:change_log_old_rows"
+	changeLogTxKey      = "orca:change_log_started_transaction"
+)
+
+// ChangeTracked 需要记录变更历史的模型，TrackChanges返回true时记录创建、更新和删除
+type ChangeTracked interface {
+	TrackChanges() bool
+}
+
+// FieldChange 字段变更前后的值，创建时Old为空，删除时New为空
+type FieldChange struct {
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+}
+
+// ChangeLog 一条记录的变更历史，Changes为字段名(数据库列名)到FieldChange的json
+type ChangeLog struct {
+	Id
+	Action    string         `json:"action" gorm:"size:16;comment:操作类型"`
+	Table     string         `json:"table" gorm:"column:table_name;size:64;index:idx_change_log_record;comment:表名"`
+	Title     string         `json:"title" gorm:"size:64;comment:表的中文名"`
+	RecordId  int64          `json:"recordId" gorm:"index:idx_change_log_record;comment:记录id"`
+	Changes   datatypes.JSON `json:"changes" gorm:"comment:变更的字段"`
+	UserId    int64          `json:"userId" gorm:"comment:操作用户"`
+	RequestId string         `json:"requestId" gorm:"size:64;comment:请求id"`
+	CreatedAt time.Time      `json:"createdAt" gorm:"comment:操作时间"`
+}
+
+func (*ChangeLog) TableName() string {
+	return "t_change_log"
+}
+
+func (*ChangeLog) TableTitle() string {
+	return "变更历史"
+}
+
+// ChangeRecorder 保存变更历史，db为产生变更的连接，在事务中时为该事务
+type ChangeRecorder interface {
+	Record(db *gorm.DB, logs []*ChangeLog) error
+}
+
+type tableRecorder struct{}
+
+// TableRecorder 将变更历史写入t_change_log表，和变更在同一个事务中，写入失败时变更也失败。
+// 语句不在事务中时由插件为其开启事务
+func TableRecorder() ChangeRecorder {
+	return tableRecorder{}
+}
+
+func (tableRecorder) Record(db *gorm.DB, logs []*ChangeLog) error {
+	return errors.WithStack(db.Session(&gorm.Session{NewDB: true}).Create(&logs).Error)
+}
+
+type streamRecorder struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+}
+
+// StreamRecorder 将变更历史逐条发布到redis stream，可用redismq.Worker消费。
+// 在RunTx或Transaction开启的事务中，或语句不在事务中时，提交后才发布，回滚时不发布，发布失败只记录日志；
+// 直接使用db.Transaction时无法得知事务是否提交，会立即发布，事务回滚时消费方会收到未生效的变更，
+// 需要与数据严格一致时使用TableRecorder。
+// maxLen为stream保留的大约条数，0表示不裁剪，裁剪时消费方落后超过maxLen条后未消费的变更历史会丢失
+func StreamRecorder(client *redis.Client, stream string, maxLen int64) ChangeRecorder {
+	return &streamRecorder{client: client, stream: stream, maxLen: maxLen}
+}
+
+// Record 消息格式与redismq.Produce一致，orm不依赖redismq以免循环引用
+func (r *streamRecorder) Record(db *gorm.DB, logs []*ChangeLog) error {
+	bodies := make([]string, 0, len(logs))
+	for _, log := range logs {
+		body, err := serialize.JsonStringify(log)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		bodies = append(bodies, string(body))
+	}
+	return AfterCommit(db, func() error {
+		// 事务提交后请求可能已经结束，不使用语句的context
+		for _, body := range bodies {
+			err := r.client.XAdd(context.Background(), &redis.XAddArgs{
+				Stream: r.stream,
+				MaxLen: r.maxLen,
+				Approx: true,
+				Values: []string{"payload", body},
+			}).Err()
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	})
+}
+
+// UseChangeRecorder 替换db记录变更历史的方式，db须由orm.New创建，默认为TableRecorder
+func UseChangeRecorder(db *gorm.DB, recorder ChangeRecorder) error {
+	plugin, ok := db.Config.Plugins[changeLogPluginName].(*changeLogPlugin)
+	if !ok {
+		return errors.New("数据库未注册变更历史插件")
+	}
+	plugin.recorder = recorder
+	return nil
+}
+
+// changeLogPlugin 记录实现了ChangeTracked的模型的变更历史。
+// 更新和删除前按语句的条件查询出原记录用于对比，批量更新大量记录时注意开销。
+// orm.New跳过了gorm的默认事务，不在事务中的语句由插件开启事务，保证变更和变更历史同时提交
+type changeLogPlugin struct {
+	recorder ChangeRecorder
+}
+
+func (p *changeLogPlugin) Name() string {
+	return changeLogPluginName
+}
+
+func (p *changeLogPlugin) Initialize(db *gorm.DB) error {
+	callbacks := []error{
+		db.Callback().Create().Before("gorm:before_create").Register("orca:change_log_begin", p.begin),
+		db.Callback().Update().Before("gorm:before_update").Register("orca:change_log_begin", p.begin),
+		db.Callback().Delete().Before("gorm:before_delete").Register("orca:change_log_begin", p.begin),
+		db.Callback().Create().After("gorm:create").Register("orca:change_log_create", p.afterCreate),
+		db.Callback().Update().Before("gorm:update").Register("orca:change_log_before_update", p.loadOldRows),
+		db.Callback().Update().After("gorm:update").Register("orca:change_log_update", p.afterUpdate),
+		db.Callback().Delete().Before("gorm:delete").Register("orca:change_log_before_delete", p.loadOldRows),
+		db.Callback().Delete().After("gorm:delete").Register("orca:change_log_delete", p.afterDelete),
+		db.Callback().Create().After("orca:change_log_create").Register("orca:change_log_commit", p.commit),
+		db.Callback().Update().After("orca:change_log_update").Register("orca:change_log_commit", p.commit),
+		db.Callback().Delete().After("orca:change_log_delete").Register("orca:change_log_commit", p.commit),
+	}
+	for _, err := range callbacks {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tracked(stmt *gorm.Statement) bool {
+	if stmt.Schema == nil {
+		return false
+	}
+	model, ok := reflect.New(stmt.Schema.ModelType).Interface().(ChangeTracked)
+	return ok && model.TrackChanges()
+}
+
+// begin 需要记录变更历史的语句不在事务中时开启事务，与gorm的默认事务相同
+func (p *changeLogPlugin) begin(db *gorm.DB) {
+	if db.Error != nil || !tracked(db.Statement) {
+		return
+	}
+	tx := db.Begin()
+	if tx.Error == gorm.ErrInvalidTransaction {
+		// 已在事务中
+		return
+	}
+	if tx.Error != nil {
+		_ = db.AddError(errors.WithStack(tx.Error))
+		return
+	}
+	db.Statement.ConnPool = tx.Statement.ConnPool
+	db.Statement.Settings.Store(afterCommitKey, &afterCommitHooks{})
+	db.InstanceSet(changeLogTxKey, true)
+}
+
+// commit 提交或回滚begin开启的事务，提交后执行语句中通过AfterCommit登记的函数
+func (p *changeLogPlugin) commit(db *gorm.DB) {
+	if _, ok := db.InstanceGet(changeLogTxKey); !ok {
+		return
+	}
+	val, _ := db.Statement.Settings.LoadAndDelete(afterCommitKey)
+	if db.Error != nil {
+		db.Rollback()
+	} else {
+		db.Commit()
+	}
+	db.Statement.ConnPool = db.Config.ConnPool
+	if db.Error != nil {
+		return
+	}
+	for _, fn := range val.(*afterCommitHooks).fns {
+		if err := fn(); err != nil {
+			zap.L().Error("事务提交后执行失败", zap.Error(err))
+		}
+	}
+}
+
+func (p *changeLogPlugin) newLog(db *gorm.DB, action string, row reflect.Value, changes map[string]*FieldChange) (*ChangeLog, error) {
+	stmt := db.Statement
+	body, err := serialize.JsonStringifyBytes(changes)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	log := &ChangeLog{
+		Action:    action,
+		Table:     stmt.Table,
+		Title:     tableTitle(reflect.New(stmt.Schema.ModelType).Interface()),
+		Changes:   body,
+		UserId:    CurrentUserId(stmt.Context),
+		RequestId: CurrentRequestId(stmt.Context),
+		CreatedAt: time.Now(),
+	}
+	if pk := stmt.Schema.PrioritizedPrimaryField; pk != nil {
+		value, _ := pk.ValueOf(stmt.Context, row)
+		log.RecordId, _ = value.(int64)
+	}
+	return log, nil
+}
+
+func (p *changeLogPlugin) record(db *gorm.DB, logs []*ChangeLog) {
+	if len(logs) == 0 || p.recorder == nil {
+		return
+	}
+	if err := p.recorder.Record(db, logs); err != nil {
+		_ = db.AddError(errors.Wrapf(err, "记录变更历史失败: %v", err))
+	}
+}
+
+// trackedFields 记录变更的字段，Id和审计字段不记录
+func trackedFields(s *schema.Schema) []*schema.Field {
+	fields := make([]*schema.Field, 0, len(s.Fields))
+	for _, field := range s.Fields {
+		if field.DBName == "" || contains(auditFields, field.Name) {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func (p *changeLogPlugin) afterCreate(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || !tracked(stmt) {
+		return
+	}
+	var rows []reflect.Value
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			rows = append(rows, reflect.Indirect(stmt.ReflectValue.Index(i)))
+		}
+	case reflect.Struct:
+		rows = append(rows, stmt.ReflectValue)
+	}
+
+	fields := trackedFields(stmt.Schema)
+	logs := make([]*ChangeLog, 0, len(rows))
+	for _, row := range rows {
+		changes := make(map[string]*FieldChange, len(fields))
+		for _, field := range fields {
+			value, _ := field.ValueOf(stmt.Context, row)
+			changes[field.DBName] = &FieldChange{New: value}
+		}
+		log, err := p.newLog(db, ChangeCreate, row, changes)
+		if err != nil {
+			_ = db.AddError(err)
+			return
+		}
+		logs = append(logs, log)
+	}
+	p.record(db, logs)
+}
+
+// primaryValues 语句中模型的主键值，gorm会将其加入更新和删除的条件
+func primaryValues(stmt *gorm.Statement) []interface{} {
+	pk := stmt.Schema.PrioritizedPrimaryField
+	if pk == nil {
+		return nil
+	}
+	var values []interface{}
+	add := func(rv reflect.Value) {
+		rv = reflect.Indirect(rv)
+		if rv.Kind() != reflect.Struct {
+			return
+		}
+		if value, zero := pk.ValueOf(stmt.Context, rv); !zero {
+			values = append(values, value)
+		}
+	}
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			add(stmt.ReflectValue.Index(i))
+		}
+	case reflect.Struct:
+		add(stmt.ReflectValue)
+	}
+	return values
+}
+
+// loadOldRows 按语句的条件从主库查询变更前的记录
+func (p *changeLogPlugin) loadOldRows(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || !tracked(stmt) {
+		return
+	}
+	tx := Primary(db.Session(&gorm.Session{NewDB: true})).Table(stmt.Table)
+	if stmt.Unscoped {
+		tx = tx.Unscoped()
+	}
+	conditions := false
+	if c, ok := stmt.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok && len(where.Exprs) > 0 {
+			tx = tx.Clauses(where)
+			conditions = true
+		}
+	}
+	if pks := primaryValues(stmt); len(pks) > 0 {
+		column := clause.Column{Table: clause.CurrentTable, Name: stmt.Schema.PrioritizedPrimaryField.DBName}
+		tx = tx.Where(clause.IN{Column: column, Values: pks})
+		conditions = true
+	}
+	if !conditions {
+		// 没有条件的语句会被gorm拒绝，不需要查询
+		return
+	}
+
+	rows := reflect.New(reflect.SliceOf(reflect.PtrTo(stmt.Schema.ModelType)))
+	if err := tx.Find(rows.Interface()).Error; err != nil {
+		_ = db.AddError(errors.Wrapf(err, "查询变更前的记录失败: %v", err))
+		return
+	}
+	db.InstanceSet(changeLogOldRowsKey, rows.Elem())
+}
+
+func oldRows(db *gorm.DB) (reflect.Value, bool) {
+	val, ok := db.InstanceGet(changeLogOldRowsKey)
+	if !ok {
+		return reflect.Value{}, false
+	}
+	rows, ok := val.(reflect.Value)
+	return rows, ok && rows.Len() > 0
+}
+
+func sameValue(a interface{}, b interface{}) bool {
+	x, err := serialize.JsonStringify(a)
+	if err != nil {
+		return false
+	}
+	y, err := serialize.JsonStringify(b)
+	if err != nil {
+		return false
+	}
+	return x == y
+}
+
+func (p *changeLogPlugin) afterUpdate(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || db.RowsAffected == 0 {
+		return
+	}
+	rows, ok := oldRows(db)
+	if !ok {
+		return
+	}
+	set, ok := stmt.Clauses["SET"].Expression.(clause.Set)
+	if !ok {
+		return
+	}
+
+	logs := make([]*ChangeLog, 0, rows.Len())
+	for i := 0; i < rows.Len(); i++ {
+		row := rows.Index(i).Elem()
+		changes := map[string]*FieldChange{}
+		for _, assignment := range set {
+			field := stmt.Schema.LookUpField(assignment.Column.Name)
+			if field == nil || contains(auditFields, field.Name) {
+				continue
+			}
+			if _, ok := assignment.Value.(clause.Expression); ok {
+				// 表达式的结果无法在更新前得知
+				continue
+			}
+			old, _ := field.ValueOf(stmt.Context, row)
+			if sameValue(old, assignment.Value) {
+				continue
+			}
+			changes[field.DBName] = &FieldChange{Old: old, New: assignment.Value}
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		log, err := p.newLog(db, ChangeUpdate, row, changes)
+		if err != nil {
+			_ = db.AddError(err)
+			return
+		}
+		logs = append(logs, log)
+	}
+	p.record(db, logs)
+}
+
+func (p *changeLogPlugin) afterDelete(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || db.RowsAffected == 0 {
+		return
+	}
+	rows, ok := oldRows(db)
+	if !ok {
+		return
+	}
+
+	fields := trackedFields(stmt.Schema)
+	logs := make([]*ChangeLog, 0, rows.Len())
+	for i := 0; i < rows.Len(); i++ {
+		row := rows.Index(i).Elem()
+		changes := make(map[string]*FieldChange, len(fields))
+		for _, field := range fields {
+			value, _ := field.ValueOf(stmt.Context, row)
+			changes[field.DBName] = &FieldChange{Old: value}
+		}
+		log, err := p.newLog(db, ChangeDelete, row, changes)
+		if err != nil {
+			_ = db.AddError(err)
+			return
+		}
+		logs = append(logs, log)
+	}
+	p.record(db, logs)
+}
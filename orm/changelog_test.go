@@ -0,0 +1,187 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/orca/serialize"
+	"reflect"
+	"testing"
+)
+
+type testOrder struct {
+	Id
+	Entity
+	Name   string
+	Amount int
+}
+
+func (*testOrder) TableName() string {
+	return "t_order"
+}
+
+func (*testOrder) TableTitle() string {
+	return "订单"
+}
+
+func (*testOrder) TrackChanges() bool {
+	return true
+}
+
+func TestChangeLog(t *testing.T) {
+	db := newTestDB(t, &testOrder{}, &testUser{}, &ChangeLog{})
+	repo := NewRepository[*testOrder](db)
+	ctx := WithRequestId(WithUserId(context.Background(), 7), "req-1")
+
+	order := &testOrder{Name: "a", Amount: 1}
+	if err := repo.Create(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Update(ctx, &testOrder{Id: order.Id, Name: "b", Amount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WithContext(ctx).Model(&testOrder{}).Where("name = ?", "b").Update("amount", 2).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Delete(ctx, order.GetId()); err != nil {
+		t.Fatal(err)
+	}
+	// 未实现ChangeTracked的模型不记录
+	if err := NewRepository[*testUser](db).Create(ctx, &testUser{Name: "u"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs []*ChangeLog
+	if err := db.Order("id").Find(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		action  string
+		changes string
+	}{
+		{ChangeCreate, `{"amount":{"new":1},"name":{"new":"a"}}`},
+		{ChangeUpdate, `{"name":{"old":"a","new":"b"}}`},
+		{ChangeUpdate, `{"amount":{"old":1,"new":2}}`},
+		{ChangeDelete, `{"amount":{"old":2},"name":{"old":"b"}}`},
+	}
+	if len(logs) != len(expected) {
+		t.Fatalf("expected %d logs, got %d", len(expected), len(logs))
+	}
+	for i, log := range logs {
+		changes := serialize.MustJsonParseBytes[map[string]interface{}](log.Changes)
+		want := serialize.MustJsonParse[map[string]interface{}](expected[i].changes)
+		if log.Action != expected[i].action || !reflect.DeepEqual(changes, want) {
+			t.Fatalf("unexpected log %d: %s %s", i, log.Action, log.Changes)
+		}
+		if log.Table != "t_order" || log.Title != "订单" || log.RecordId != order.GetId() || log.UserId != 7 || log.RequestId != "req-1" {
+			t.Fatalf("unexpected log %d: %+v", i, log)
+		}
+	}
+}
+
+func TestChangeLogWithoutTransaction(t *testing.T) {
+	// 没有t_change_log表，写入变更历史失败
+	db := newTestDB(t, &testOrder{})
+	repo := NewRepository[*testOrder](db)
+	ctx := context.Background()
+
+	if err := repo.Create(ctx, &testOrder{Name: "a"}); err == nil {
+		t.Fatal("expected change log error")
+	}
+	var count int64
+	db.Model(&testOrder{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("expected create to be rolled back, got %d", count)
+	}
+
+	if err := UseChangeRecorder(db, nil); err != nil {
+		t.Fatal(err)
+	}
+	order := &testOrder{Name: "b"}
+	if err := repo.Create(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+	if err := UseChangeRecorder(db, TableRecorder()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Update(ctx, &testOrder{Id: order.Id, Name: "c"}); err == nil {
+		t.Fatal("expected change log error")
+	}
+	got, err := repo.Get(ctx, order.GetId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "b" {
+		t.Fatalf("expected update to be rolled back, got %s", got.Name)
+	}
+}
+
+// xaddRecorder 记录XADD命令，不连接redis
+type xaddRecorder struct {
+	streams []string
+	trimmed bool
+}
+
+func (h *xaddRecorder) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if cmd.Name() == "xadd" {
+		h.streams = append(h.streams, fmt.Sprint(cmd.Args()[1]))
+		for _, arg := range cmd.Args() {
+			h.trimmed = h.trimmed || arg == "maxlen"
+		}
+	}
+	return ctx, errors.New("redis disabled in test")
+}
+
+func (h *xaddRecorder) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h *xaddRecorder) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *xaddRecorder) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func TestStreamRecorderAfterCommit(t *testing.T) {
+	db := newTestDB(t, &testOrder{})
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	defer client.Close()
+	hook := &xaddRecorder{}
+	client.AddHook(hook)
+	if err := UseChangeRecorder(db, StreamRecorder(client, "changes", 0)); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository[*testOrder](db)
+
+	err := repo.Transaction(context.Background(), func(ctx context.Context) error {
+		if err := repo.Create(ctx, &testOrder{Name: "a"}); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	if err == nil || len(hook.streams) != 0 {
+		t.Fatalf("expected nothing published after rollback, got %v", hook.streams)
+	}
+
+	err = repo.Transaction(context.Background(), func(ctx context.Context) error {
+		if err := repo.Create(ctx, &testOrder{Name: "b"}); err != nil {
+			return err
+		}
+		if len(hook.streams) != 0 {
+			t.Fatal("published before commit")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hook.streams, []string{"changes"}) {
+		t.Fatalf("expected one message after commit, got %v", hook.streams)
+	}
+	if hook.trimmed {
+		t.Fatal("expected the stream not to be trimmed")
+	}
+}
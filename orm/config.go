@@ -1,9 +1,37 @@
 package orm
 
+import "time"
+
 type Config struct {
 	// Dsn data source name eg. username:passwd@tcp(127.0.0.1:3306)/dbname?charset=utf8mb4&parseTime=True&loc=Local
 	Dsn string
-	// 'postgres' or 'mysql'
+	// 'postgres', 'mysql' or 'sqlite'. sqlite的dsn为文件路径，为空或":memory:"时使用内存数据库
 	Type  string
 	Debug bool
+	// Replicas 只读副本的dsn，类型与主库相同。配置后查询走副本，写入、加锁查询和事务走主库
+	Replicas []string
+	// MaxOpenConns 最大连接数，0表示不限制
+	MaxOpenConns int
+	// MaxIdleConns 最大空闲连接数，0表示使用database/sql的默认值2
+	MaxIdleConns int
+	// ConnMaxLifetime 连接最长使用时间，0表示不限制
+	ConnMaxLifetime time.Duration
+	// ConnMaxIdleTime 连接最长空闲时间，0表示不限制
+	ConnMaxIdleTime time.Duration
+	// SystemUserId 获取不到当前用户(例如后台任务)时，填充CreatedBy、UpdatedBy使用的用户id，为0时不填充
+	SystemUserId int64
+	// ChangeLogStream 变更历史发布到的redis stream，为空时写入t_change_log表。只记录实现了ChangeTracked的模型
+	ChangeLogStream string
+	// ChangeLogStreamMaxLen 变更历史stream保留的大约条数，0表示不裁剪。消费方落后超过该条数时未消费的变更历史会丢失
+	ChangeLogStreamMaxLen int64
+	// Sequence 树节点code使用的序列：db(默认)、redis或postgres，只对默认数据库生效
+	Sequence string
+	// SequenceBlock 每次预分配的序列号数量，大于1时缓存在进程内，未使用的序列号在重启后丢弃
+	SequenceBlock int
+	// CodeCodec 树节点code的编码：base64(默认，固定4位，不可排序)或sortable(可排序的变长编码)，只对默认数据库生效
+	CodeCodec string
+	// CodeWidth sortable编码的最小位数，默认为3
+	CodeWidth int
+	// ConnectTimeout 初始化时连接数据库的超时时间，0表示不限制
+	ConnectTimeout time.Duration
 }
@@ -0,0 +1,144 @@
+package orm
+
+import (
+	"context"
+	"database/sql"
+	"github.com/vuuvv/errors"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type txContextKey struct{}
+
+type userContextKey struct{}
+
+type requestIdContextKey struct{}
+
+const afterCommitKey = "orca:after_commit"
+
+// WithTx 将事务放入context，Repository和Conn会使用context中的事务
+func WithTx(ctx context.Context, tx *gorm.DB) context.Context {
+	return context.WithValue(ctx, txContextKey{}, tx)
+}
+
+// TxFrom 获取context中的事务，没有时返回nil
+func TxFrom(ctx context.Context) *gorm.DB {
+	tx, _ := ctx.Value(txContextKey{}).(*gorm.DB)
+	return tx
+}
+
+// Conn 返回context中的事务，没有事务时返回绑定了ctx的db
+func Conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx := TxFrom(ctx); tx != nil {
+		return tx
+	}
+	return db.WithContext(ctx)
+}
+
+// Transaction 在事务中执行fn，context中已有事务时加入该事务，否则开启新事务并放入传给fn的context
+func Transaction(ctx context.Context, db *gorm.DB, fn func(ctx context.Context) error, opts ...*sql.TxOptions) error {
+	if TxFrom(ctx) != nil {
+		return fn(ctx)
+	}
+	return RunTx(db.WithContext(ctx), func(tx *gorm.DB) error {
+		return fn(WithTx(ctx, tx))
+	}, opts...)
+}
+
+// afterCommitHooks 事务中通过AfterCommit登记的函数
+type afterCommitHooks struct {
+	fns []func() error
+}
+
+// RunTx 与db.Transaction相同，提交后依次执行事务中通过AfterCommit登记的函数，回滚时丢弃。
+// 在RunTx的事务中嵌套调用时使用savepoint，登记的函数随外层事务提交后执行
+func RunTx(db *gorm.DB, fc func(tx *gorm.DB) error, opts ...*sql.TxOptions) error {
+	parent, nested := db.Get(afterCommitKey)
+	hooks := &afterCommitHooks{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return fc(tx.Set(afterCommitKey, hooks).Session(&gorm.Session{}))
+	}, opts...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if nested {
+		p := parent.(*afterCommitHooks)
+		p.fns = append(p.fns, hooks.fns...)
+		return nil
+	}
+	for _, fn := range hooks.fns {
+		if err := fn(); err != nil {
+			zap.L().Error("事务提交后执行失败", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// AfterCommit 在db的事务提交后执行fn，用于发布消息、失效缓存等不能回滚的操作。
+// db在RunTx或Transaction开启的事务中时登记fn并返回nil，fn的错误只记录日志；
+// 否则立即执行fn并返回其错误，直接使用db.Transaction开启的事务无法得知是否提交，也会立即执行
+func AfterCommit(db *gorm.DB, fn func() error) error {
+	if val, ok := db.Get(afterCommitKey); ok {
+		hooks := val.(*afterCommitHooks)
+		hooks.fns = append(hooks.fns, fn)
+		return nil
+	}
+	return fn()
+}
+
+// UserProvider 获取当前操作用户的id，用于填充CreatedBy、UpdatedBy，获取不到时返回0
+type UserProvider func(ctx context.Context) int64
+
+var userProvider UserProvider = func(ctx context.Context) int64 {
+	id, _ := ctx.Value(userContextKey{}).(int64)
+	return id
+}
+
+// SetUserProvider 设置获取当前用户的方法，server包会设置为从AccessToken获取
+func SetUserProvider(provider UserProvider) {
+	userProvider = provider
+}
+
+// WithUserId 将当前用户放入context，用于后台任务等没有AccessToken的场景
+func WithUserId(ctx context.Context, id int64) context.Context {
+	return context.WithValue(ctx, userContextKey{}, id)
+}
+
+// CurrentUserId 获取当前操作用户的id，context中通过WithUserId设置的用户优先
+func CurrentUserId(ctx context.Context) int64 {
+	if ctx == nil {
+		return 0
+	}
+	if id, ok := ctx.Value(userContextKey{}).(int64); ok {
+		return id
+	}
+	return userProvider(ctx)
+}
+
+// RequestIdProvider 获取当前请求的id，用于变更历史，获取不到时返回空字符串
+type RequestIdProvider func(ctx context.Context) string
+
+var requestIdProvider RequestIdProvider = func(ctx context.Context) string {
+	return ""
+}
+
+// SetRequestIdProvider 设置获取当前请求id的方法，server包会设置为从请求头X-Request-Id获取
+func SetRequestIdProvider(provider RequestIdProvider) {
+	requestIdProvider = provider
+}
+
+// WithRequestId 将请求id放入context，用于消费消息等不在http请求中的场景
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdContextKey{}, id)
+}
+
+// CurrentRequestId 获取当前请求的id，context中通过WithRequestId设置的id优先
+func CurrentRequestId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if id, ok := ctx.Value(requestIdContextKey{}).(string); ok {
+		return id
+	}
+	return requestIdProvider(ctx)
+}
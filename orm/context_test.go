@@ -0,0 +1,46 @@
+package orm
+
+import (
+	"github.com/vuuvv/errors"
+	"gorm.io/gorm"
+	"reflect"
+	"testing"
+)
+
+func TestAfterCommit(t *testing.T) {
+	db := newTestDB(t)
+	var calls []string
+	record := func(name string) func() error {
+		return func() error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+
+	_ = AfterCommit(db, record("direct"))
+	err := RunTx(db, func(tx *gorm.DB) error {
+		_ = AfterCommit(tx, record("outer"))
+		// savepoint回滚时丢弃其中登记的函数
+		_ = RunTx(tx, func(tx *gorm.DB) error {
+			_ = AfterCommit(tx, record("rollback"))
+			return errors.New("rollback")
+		})
+		err := RunTx(tx, func(tx *gorm.DB) error {
+			return AfterCommit(tx, record("nested"))
+		})
+		if len(calls) != 1 {
+			t.Fatalf("expected hooks to wait for commit, got %v", calls)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = RunTx(db, func(tx *gorm.DB) error {
+		_ = AfterCommit(tx, record("failed"))
+		return errors.New("failed")
+	})
+	if !reflect.DeepEqual(calls, []string{"direct", "outer", "nested"}) {
+		t.Fatalf("unexpected calls: %v", calls)
+	}
+}
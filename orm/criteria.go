@@ -0,0 +1,260 @@
+package orm
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/orca/orm/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"strings"
+)
+
+type Criteria struct {
+	table string
+	field string
+	op    string
+	// path json字段中的路径，不为空时比较json路径上的值
+	path []string
+}
+
+// String 返回过滤条件的sql，BETWEEN为两个占位符，IN的参数为数组
+//
+// Deprecated: 不支持json路径条件，PageExecutor通过build同时生成sql和参数
+func (c *Criteria) String(db *gorm.DB) string {
+	var value interface{}
+	switch c.op {
+	case OP_In, OP_NotIn:
+		value = []interface{}{nil}
+	case OP_Between, OP_NotBetween:
+		value = []interface{}{0, 0}
+	case OP_IsNull, OP_IsNotNull:
+		value = true
+	}
+	sql, _, _ := c.build(db, value)
+	return sql
+}
+
+// value 将过滤条件的值转换为sql参数
+func (c *Criteria) value(val interface{}) interface{} {
+	switch c.op {
+	case OP_Contain, OP_IContain:
+		val = fmt.Sprintf("%%%v%%", val)
+	case OP_StartsWith:
+		val = fmt.Sprintf("%v%%", val)
+	case OP_EndsWith:
+		val = fmt.Sprintf("%%%v", val)
+	}
+	if val == true {
+		val = 1
+	} else if val == false {
+		val = 0
+	}
+	return val
+}
+
+// column 比较的列，json路径时使用datatypes.JSONQuery取出路径上的值
+func (c *Criteria) column(db *gorm.DB, value interface{}) (string, []interface{}) {
+	if len(c.path) == 0 {
+		if c.table == "" {
+			return Quote(db, c.field), nil
+		}
+		return fmt.Sprintf("%s.%s", Quote(db, c.table), Quote(db, c.field)), nil
+	}
+	column := c.field
+	if c.table != "" {
+		column = c.table + "." + c.field
+	}
+	return buildExpression(db, datatypes.JSONQuery(column).Extract(isNumber(value), c.path...))
+}
+
+// build 生成过滤条件的sql和参数
+func (c *Criteria) build(db *gorm.DB, value interface{}) (string, []interface{}, error) {
+	value = normalizeNumber(value)
+	column, args := c.column(db, value)
+	switch c.op {
+	case OP_IsNull, OP_IsNotNull:
+		isNull := c.op == OP_IsNull
+		if b, ok := value.(bool); ok && !b {
+			isNull = !isNull
+		}
+		if isNull {
+			return column + " IS NULL", args, nil
+		}
+		return column + " IS NOT NULL", args, nil
+	case OP_In, OP_NotIn:
+		values := toSlice(value)
+		if values == nil {
+			values = []interface{}{value}
+		}
+		return fmt.Sprintf("%s %s ?", column, c.op), append(args, values), nil
+	case OP_Between, OP_NotBetween:
+		values := toSlice(value)
+		if len(values) != 2 {
+			return "", nil, errors.Errorf("过滤条件[%s]的值需为两个元素的数组", c.field)
+		}
+		if c.op == OP_Between {
+			// 一端为空时为开区间
+			switch {
+			case values[0] == nil && values[1] == nil:
+				return "1=1", nil, nil
+			case values[0] == nil:
+				return column + " <= ?", append(args, values[1]), nil
+			case values[1] == nil:
+				return column + " >= ?", append(args, values[0]), nil
+			}
+		}
+		return fmt.Sprintf("%s %s ? AND ?", column, c.op), append(args, values[0], values[1]), nil
+	case OP_IContain:
+		if db.Dialector.Name() == "postgres" {
+			return column + " ILIKE ?", append(args, c.value(value)), nil
+		}
+		return fmt.Sprintf("LOWER(%s) LIKE LOWER(?)", column), append(args, c.value(value)), nil
+	case OP_Contain, OP_StartsWith, OP_EndsWith:
+		return column + " LIKE ?", append(args, c.value(value)), nil
+	default:
+		return fmt.Sprintf("%s %s ?", column, c.op), append(args, c.value(value)), nil
+	}
+}
+
+// normalizeNumber 将json.Number转为int64或float64，jsoniter的UseNumber解析为encoding/json的Number
+func normalizeNumber(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i, item := range v {
+			ret[i] = normalizeNumber(item)
+		}
+		return ret
+	}
+	return value
+}
+
+// preciseNumber 将value中的float64替换为number中相同位置上值相等的json.Number，
+// Paginator.Filters在GetPaginator之后被修改的部分保持不变
+func preciseNumber(value interface{}, number interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if n, ok := number.(json.Number); ok {
+			if f, err := n.Float64(); err == nil && f == v {
+				return n
+			}
+		}
+	case map[string]interface{}:
+		if m, ok := number.(map[string]interface{}); ok && v != nil {
+			ret := make(map[string]interface{}, len(v))
+			for k, item := range v {
+				ret[k] = preciseNumber(item, m[k])
+			}
+			return ret
+		}
+	case []interface{}:
+		if s, ok := number.([]interface{}); ok && len(s) == len(v) {
+			ret := make([]interface{}, len(v))
+			for i, item := range v {
+				ret[i] = preciseNumber(item, s[i])
+			}
+			return ret
+		}
+	}
+	return value
+}
+
+func toSlice(value interface{}) []interface{} {
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil
+	}
+	if _, ok := value.([]byte); ok {
+		return nil
+	}
+	ret := make([]interface{}, rv.Len())
+	for i := range ret {
+		ret[i] = rv.Index(i).Interface()
+	}
+	return ret
+}
+
+func isNumber(value interface{}) bool {
+	if values := toSlice(value); len(values) > 0 {
+		value = values[0]
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// rawDialector 生成sql时参数使用?占位，用于拼接到Raw的模板中
+type rawDialector struct {
+	gorm.Dialector
+}
+
+func (rawDialector) BindVarTo(writer clause.Writer, stmt *gorm.Statement, v interface{}) {
+	_ = writer.WriteByte('?')
+}
+
+// buildExpression 将gorm表达式转为使用?占位的sql和参数
+func buildExpression(db *gorm.DB, expr clause.Expression) (string, []interface{}) {
+	config := *db.Config
+	config.Dialector = rawDialector{db.Dialector}
+	stmt := &gorm.Statement{DB: &gorm.DB{Config: &config}, Clauses: map[string]clause.Clause{}}
+	stmt.DB.Statement = stmt
+	expr.Build(stmt)
+	return stmt.SQL.String(), stmt.Vars
+}
+
+// buildGroup 生成一组过滤条件，names为需要生成的条件，条件组通过FilterAnd和FilterOr嵌套
+func (p *PageExecutor) buildGroup(db *gorm.DB, values map[string]interface{}, names []string, sep string) (string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(sql string, a []interface{}) {
+		conditions = append(conditions, sql)
+		args = append(args, a...)
+	}
+	for _, name := range names {
+		value, ok := values[name]
+		if !ok {
+			continue
+		}
+		if name == FilterAnd || name == FilterOr {
+			groupSep := " AND "
+			if name == FilterOr {
+				groupSep = " OR "
+			}
+			var groups []string
+			var groupArgs []interface{}
+			for _, item := range value.([]map[string]interface{}) {
+				sql, a, err := p.buildGroup(db, item, sortedKeys(item), " AND ")
+				if err != nil {
+					return "", nil, err
+				}
+				groups = append(groups, sql)
+				groupArgs = append(groupArgs, a...)
+			}
+			if len(groups) > 0 {
+				add("("+strings.Join(groups, groupSep)+")", groupArgs)
+			}
+			continue
+		}
+		sql, a, err := p.criteria[name].build(db, value)
+		if err != nil {
+			return "", nil, err
+		}
+		add(sql, a)
+	}
+	if len(conditions) == 0 {
+		return "1=1", nil, nil
+	}
+	return "(" + strings.Join(conditions, sep) + ")", args, nil
+}
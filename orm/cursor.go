@@ -0,0 +1,325 @@
+package orm
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/vuuvv/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// cursorVar 游标分页时模板中的${cursor}会被替换为游标条件，第一页时为1=1
+const cursorVar = "cursor"
+
+// cursor 游标，记录一页第一行或最后一行的排序字段的值
+type cursor struct {
+	// Keys 排序字段的值
+	Keys []jsoniter.RawMessage `json:"k"`
+	// Sort 生成游标时的排序，排序改变后游标失效
+	Sort string `json:"s"`
+	// Prev 是否向前翻页
+	Prev bool `json:"p,omitempty"`
+}
+
+func encodeCursor(c *cursor) (string, error) {
+	data, err := jsoniter.Marshal(c)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(value string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("分页游标不正确")
+	}
+	c := &cursor{}
+	if err = jsoniter.Unmarshal(data, c); err != nil {
+		return nil, errors.New("分页游标不正确")
+	}
+	return c, nil
+}
+
+// orderKey 游标分页的一个排序字段
+type orderKey struct {
+	// sql 排序字段的sql，例如`u`.`id`
+	sql string
+	// column 查询结果中的列名
+	column string
+	asc    bool
+}
+
+func (k *orderKey) String() string {
+	if k.asc {
+		return k.column
+	}
+	return "-" + k.column
+}
+
+func (p *PageExecutor) orderKeys(db *gorm.DB, page *Paginator) ([]*orderKey, error) {
+	var keys []*orderKey
+	for _, s := range page.Sort {
+		f, ok := p.sortFields[s.Field]
+		if !ok {
+			return nil, errors.Errorf("不支持的排序字段: %s", s.Field)
+		}
+		keys = append(keys, &orderKey{sql: f.column(db), column: f.field, asc: s.Asc})
+	}
+	for _, o := range p.orderBy {
+		key := &orderKey{sql: o.Field, column: o.Field, asc: o.ASC}
+		if o.Table != "" {
+			key.sql = fmt.Sprintf("%s.%s", Quote(db, o.Table), Quote(db, o.Field))
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("游标分页需指定排序，且最后一个排序字段需唯一")
+	}
+	return keys, nil
+}
+
+func sortSignature(keys []*orderKey) string {
+	names := make([]string, 0, len(keys))
+	for _, k := range keys {
+		names = append(names, k.String())
+	}
+	return strings.Join(names, ",")
+}
+
+// cursorCondition 生成游标条件，排序方向一致时使用(a,b) > (?,?)，否则展开为(a > ?) OR (a = ? AND b > ?)
+func cursorCondition(keys []*orderKey, values []interface{}, prev bool) (string, []interface{}) {
+	sameDirection := true
+	for _, k := range keys[1:] {
+		if k.asc != keys[0].asc {
+			sameDirection = false
+			break
+		}
+	}
+	op := func(k *orderKey) string {
+		if k.asc != prev {
+			return ">"
+		}
+		return "<"
+	}
+
+	if len(keys) == 1 {
+		return fmt.Sprintf("%s %s ?", keys[0].sql, op(keys[0])), values
+	}
+	if sameDirection {
+		columns := make([]string, 0, len(keys))
+		for _, k := range keys {
+			columns = append(columns, k.sql)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(keys)), ",")
+		return fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ","), op(keys[0]), placeholders), values
+	}
+
+	var ors []string
+	var args []interface{}
+	for i, k := range keys {
+		var ands []string
+		for j := 0; j < i; j++ {
+			ands = append(ands, fmt.Sprintf("%s = ?", keys[j].sql))
+			args = append(args, values[j])
+		}
+		ands = append(ands, fmt.Sprintf("%s %s ?", k.sql, op(k)))
+		args = append(args, values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+func cursorOrderBy(keys []*orderKey, prev bool) string {
+	orders := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if k.asc != prev {
+			orders = append(orders, k.sql+" ASC")
+		} else {
+			orders = append(orders, k.sql+" DESC")
+		}
+	}
+	return strings.Join(orders, ",")
+}
+
+var cursorSchemas sync.Map
+
+// rowValues 读取查询结果的元素类型，结构体按gorm的字段映射读取列
+type rowValues struct {
+	elem   reflect.Type
+	ptr    bool
+	schema *schema.Schema
+}
+
+func newRowValues(db *gorm.DB, items reflect.Value) (*rowValues, error) {
+	if items.Kind() != reflect.Slice {
+		return nil, errors.New("游标分页的items需为切片的指针")
+	}
+	r := &rowValues{elem: items.Type().Elem()}
+	if r.elem.Kind() == reflect.Ptr {
+		r.ptr = true
+		r.elem = r.elem.Elem()
+	}
+	switch r.elem.Kind() {
+	case reflect.Struct:
+		s, err := schema.Parse(reflect.New(r.elem).Interface(), &cursorSchemas, db.NamingStrategy)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		r.schema = s
+	case reflect.Map:
+		if r.elem.Key().Kind() != reflect.String {
+			return nil, errors.New("游标分页的items元素需为结构体或map[string]interface{}")
+		}
+	default:
+		return nil, errors.New("游标分页的items元素需为结构体或map[string]interface{}")
+	}
+	return r, nil
+}
+
+func (r *rowValues) get(row reflect.Value, column string) (interface{}, error) {
+	if r.ptr {
+		row = row.Elem()
+	}
+	if r.schema == nil {
+		value := row.MapIndex(reflect.ValueOf(column))
+		if !value.IsValid() {
+			return nil, errors.Errorf("查询结果中缺少排序字段: %s", column)
+		}
+		return value.Interface(), nil
+	}
+	field := r.schema.LookUpField(column)
+	if field == nil {
+		return nil, errors.Errorf("查询结果中缺少排序字段: %s", column)
+	}
+	return field.ReflectValueOf(context.Background(), row).Interface(), nil
+}
+
+// decode 将游标中的值转换为排序字段的类型，避免大整数丢失精度
+func (r *rowValues) decode(raw jsoniter.RawMessage, column string) (interface{}, error) {
+	if r.schema != nil {
+		if field := r.schema.LookUpField(column); field != nil {
+			ptr := reflect.New(field.FieldType)
+			if err := jsoniter.Unmarshal(raw, ptr.Interface()); err != nil {
+				return nil, errors.New("分页游标不正确")
+			}
+			return ptr.Elem().Interface(), nil
+		}
+	}
+	var value interface{}
+	decoder := jsoniter.Config{UseNumber: true}.Froze()
+	if err := decoder.Unmarshal(raw, &value); err != nil {
+		return nil, errors.New("分页游标不正确")
+	}
+	if n, ok := value.(jsoniter.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return i, nil
+		}
+		f, _ := n.Float64()
+		return f, nil
+	}
+	return value, nil
+}
+
+func (r *rowValues) cursor(row reflect.Value, keys []*orderKey, prev bool) (string, error) {
+	c := &cursor{Sort: sortSignature(keys), Prev: prev}
+	for _, k := range keys {
+		value, err := r.get(row, k.column)
+		if err != nil {
+			return "", err
+		}
+		raw, err := jsoniter.Marshal(value)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		c.Keys = append(c.Keys, raw)
+	}
+	return encodeCursor(c)
+}
+
+// queryCursor 游标分页，不查询总数，多查询一行用于判断是否还有下一页
+func (p *PageExecutor) queryCursor(db *gorm.DB, page *Paginator, items interface{}, template string, vars map[string]string, filters map[string]interface{}, args map[string][]interface{}) (*Page, error) {
+	if !strings.Contains(template, "${"+cursorVar) {
+		return nil, errors.New("游标分页的sql模板中需包含${cursor}")
+	}
+	keys, err := p.orderKeys(db, page)
+	if err != nil {
+		return nil, err
+	}
+	itemsValue := reflect.ValueOf(items)
+	if itemsValue.Kind() != reflect.Ptr {
+		return nil, errors.New("游标分页的items需为切片的指针")
+	}
+	itemsValue = itemsValue.Elem()
+	rows, err := newRowValues(db, itemsValue)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &Page{Page: 1, PageSize: page.PageSize}
+	if ret.PageSize <= 0 {
+		ret.PageSize = 20
+	}
+
+	prev := false
+	vars[cursorVar], args[cursorVar] = "1=1", nil
+	if page.Cursor != "" {
+		c, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if c.Sort != sortSignature(keys) || len(c.Keys) != len(keys) {
+			return nil, errors.New("分页游标与排序不匹配")
+		}
+		values := make([]interface{}, 0, len(keys))
+		for i, k := range keys {
+			value, err := rows.decode(c.Keys[i], k.column)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		prev = c.Prev
+		vars[cursorVar], args[cursorVar] = cursorCondition(keys, values, prev)
+	}
+
+	sql, values := prepare(template, vars, filters, args)
+	sql = fmt.Sprintf("%s\nORDER BY %s\nLIMIT %d", sql, cursorOrderBy(keys, prev), ret.PageSize+1)
+	err = db.Raw(sql, values...).Scan(items).Error
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	more := itemsValue.Len() > ret.PageSize
+	if more {
+		itemsValue.Set(itemsValue.Slice(0, ret.PageSize))
+	}
+	n := itemsValue.Len()
+	if prev {
+		swap := reflect.Swapper(itemsValue.Interface())
+		for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+
+	if n > 0 {
+		// 向后翻页时，有更多数据才有下一页，翻过页才有上一页；向前翻页时相反
+		if (!prev && more) || prev {
+			if ret.Next, err = rows.cursor(itemsValue.Index(n-1), keys, false); err != nil {
+				return nil, err
+			}
+		}
+		if (prev && more) || (!prev && page.Cursor != "") {
+			if ret.Prev, err = rows.cursor(itemsValue.Index(0), keys, true); err != nil {
+				return nil, err
+			}
+		}
+	}
+	ret.Items = items
+	return ret, nil
+}
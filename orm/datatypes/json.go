@@ -114,6 +114,8 @@ type JSONQueryExpression struct {
 	hasKeys     bool
 	equals      bool
 	equalsValue interface{}
+	extract     bool
+	asNumber    bool
 }
 
 // JSONQuery query column as json
@@ -136,12 +138,33 @@ func (jsonQuery *JSONQueryExpression) Equals(value interface{}, keys ...string)
 	return jsonQuery
 }
 
+// Extract 取出json路径上的值，用于和其他值比较，asNumber为true时按数字比较
+func (jsonQuery *JSONQueryExpression) Extract(asNumber bool, keys ...string) *JSONQueryExpression {
+	jsonQuery.keys = keys
+	jsonQuery.extract = true
+	jsonQuery.asNumber = asNumber
+	return jsonQuery
+}
+
 // Build implements clause.Expression
 func (jsonQuery *JSONQueryExpression) Build(builder clause.Builder) {
 	if stmt, ok := builder.(*gorm.Statement); ok {
 		switch stmt.Dialector.Name() {
 		case "mysql", "sqlite":
 			switch {
+			case jsonQuery.extract:
+				if len(jsonQuery.keys) > 0 {
+					unquote := stmt.Dialector.Name() == "mysql" && !jsonQuery.asNumber
+					if unquote {
+						_, _ = builder.WriteString("JSON_UNQUOTE(")
+					}
+					_, _ = builder.WriteString("JSON_EXTRACT(" + stmt.Quote(jsonQuery.column) + ",")
+					builder.AddVar(stmt, "$."+strings.Join(jsonQuery.keys, "."))
+					_, _ = builder.WriteString(")")
+					if unquote {
+						_, _ = builder.WriteString(")")
+					}
+				}
 			case jsonQuery.hasKeys:
 				if len(jsonQuery.keys) > 0 {
 					_, _ = builder.WriteString("JSON_EXTRACT(" + stmt.Quote(jsonQuery.column) + ",")
@@ -162,6 +185,23 @@ func (jsonQuery *JSONQueryExpression) Build(builder clause.Builder) {
 			}
 		case "postgres":
 			switch {
+			case jsonQuery.extract:
+				if len(jsonQuery.keys) > 0 {
+					if jsonQuery.asNumber {
+						_, _ = builder.WriteString("(")
+					}
+					_, _ = builder.WriteString(fmt.Sprintf("json_extract_path_text(%v::json,", stmt.Quote(jsonQuery.column)))
+					for idx, key := range jsonQuery.keys {
+						if idx > 0 {
+							_ = builder.WriteByte(',')
+						}
+						stmt.AddVar(builder, key)
+					}
+					_, _ = builder.WriteString(")")
+					if jsonQuery.asNumber {
+						_, _ = builder.WriteString(")::numeric")
+					}
+				}
 			case jsonQuery.hasKeys:
 				if len(jsonQuery.keys) > 0 {
 					stmt.WriteQuoted(jsonQuery.column)
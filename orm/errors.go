@@ -8,39 +8,25 @@ import (
 )
 
 func DuplicateMessage(err error, messages map[string]string) error {
-	rawErr := err
-	if errors.HasStack(rawErr) {
-		rawErr = errors.Unwrap(rawErr)
-		if rawErr == nil {
-			rawErr = err
-		}
-	}
-	switch val := rawErr.(type) {
-	case *mysql.MySQLError:
-		if val.Number == 1062 {
-			for k, v := range messages {
-				if strings.Contains(val.Message, k) {
-					return errors.Wrap(err, v)
-				}
-			}
-			return errors.Wrap(err, "插入重复数据")
-		}
-
-	case *pgconn.PgError:
-		if val.Code == "23505" {
-			for k, v := range messages {
-				if strings.Contains(val.Message, k) {
-					return errors.Wrap(err, v)
-				}
+	if message, ok := duplicateError(err); ok {
+		for k, v := range messages {
+			if strings.Contains(message, k) {
+				return errors.Wrap(err, v)
 			}
-			return errors.Wrap(err, "插入重复数据")
 		}
+		return errors.Wrap(err, "插入重复数据")
 	}
 
 	return errors.WithStack(err)
 }
 
 func IsDuplicateError(err error) bool {
+	_, ok := duplicateError(err)
+	return ok
+}
+
+// duplicateError 判断是否违反唯一约束，是则返回数据库的错误信息
+func duplicateError(err error) (message string, ok bool) {
 	rawErr := err
 	if errors.HasStack(rawErr) {
 		rawErr = errors.Unwrap(rawErr)
@@ -51,13 +37,15 @@ func IsDuplicateError(err error) bool {
 	switch val := rawErr.(type) {
 	case *mysql.MySQLError:
 		if val.Number == 1062 {
-			return true
+			return val.Message, true
 		}
 
 	case *pgconn.PgError:
 		if val.Code == "23505" {
-			return true
+			return val.Message, true
 		}
+	default:
+		return sqliteDuplicateError(rawErr)
 	}
-	return false
+	return "", false
 }
@@ -0,0 +1,8 @@
+//go:build !cgo
+
+package orm
+
+// sqliteDuplicateError 未启用cgo时sqlite驱动不可用
+func sqliteDuplicateError(err error) (message string, ok bool) {
+	return "", false
+}
@@ -0,0 +1,14 @@
+//go:build cgo
+
+package orm
+
+import "github.com/mattn/go-sqlite3"
+
+func sqliteDuplicateError(err error) (message string, ok bool) {
+	if val, isSqlite := err.(sqlite3.Error); isSqlite {
+		if val.ExtendedCode == sqlite3.ErrConstraintUnique || val.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return val.Error(), true
+		}
+	}
+	return "", false
+}
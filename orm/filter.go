@@ -0,0 +1,187 @@
+package orm
+
+import (
+	jsoniter "github.com/json-iterator/go"
+	"github.com/vuuvv/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// filtersVar 模板中的${filters}会被替换为通过结构体tag声明的所有生效的过滤条件，用AND连接，没有时为1=1
+const filtersVar = "filters"
+
+// filterField 通过结构体tag声明的过滤条件，例如
+//
+//	type UserQuery struct {
+//		Name   string  `filter:"name,table=u,op=CONTAIN"`
+//		RoleId int64   `filter:"roleId,table=r,field=id"`
+//		Ids    []int64 `filter:"ids,table=u,field=id,op=IN"`
+//	}
+//
+// name默认为json名称，field默认为字段名的蛇形命名，op默认为=。
+// json字段可以使用path比较json路径上的值，例如`filter:"color,field=attrs,path=spec.color"`
+type filterField struct {
+	name     string
+	typ      reflect.Type
+	criteria *Criteria
+}
+
+var filterFieldsCache sync.Map
+
+func parseFilterFields(typ reflect.Type) ([]*filterField, error) {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, errors.Errorf("过滤条件需为结构体: %s", typ)
+	}
+	if cached, ok := filterFieldsCache.Load(typ); ok {
+		return cached.([]*filterField), nil
+	}
+
+	var fields []*filterField
+	naming := schema.NamingStrategy{}
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		tag, ok := sf.Tag.Lookup("filter")
+		if !ok || tag == "-" || !sf.IsExported() {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		f := &filterField{
+			name:     strings.TrimSpace(parts[0]),
+			typ:      sf.Type,
+			criteria: &Criteria{field: naming.ColumnName("", sf.Name), op: OP_Equal},
+		}
+		if f.name == "" {
+			f.name = jsonName(sf)
+		}
+		if f.name == filtersVar {
+			return nil, errors.Errorf("过滤条件[%s.%s]不能命名为%s", typ.Name(), sf.Name, filtersVar)
+		}
+		for _, part := range parts[1:] {
+			kv := strings.SplitN(part, "=", 2)
+			if len(kv) != 2 {
+				return nil, errors.Errorf("过滤条件[%s.%s]的tag格式不正确: %s", typ.Name(), sf.Name, tag)
+			}
+			value := strings.TrimSpace(kv[1])
+			switch strings.TrimSpace(kv[0]) {
+			case "table":
+				f.criteria.table = value
+			case "field":
+				f.criteria.field = value
+			case "op":
+				f.criteria.op = strings.ToUpper(value)
+			case "path":
+				f.criteria.path = strings.Split(value, ".")
+			default:
+				return nil, errors.Errorf("过滤条件[%s.%s]的tag不支持: %s", typ.Name(), sf.Name, kv[0])
+			}
+		}
+		fields = append(fields, f)
+	}
+
+	filterFieldsCache.Store(typ, fields)
+	return fields, nil
+}
+
+func jsonName(sf reflect.StructField) string {
+	name := strings.Split(sf.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return sf.Name
+	}
+	return name
+}
+
+// bind 将过滤条件的值转换为声明的类型
+func (f *filterField) bind(value interface{}) (interface{}, error) {
+	raw, err := jsoniter.Marshal(value)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	ptr := reflect.New(f.typ)
+	if err = jsoniter.Unmarshal(raw, ptr.Interface()); err != nil {
+		// 兼容用字符串传递的数字和布尔值，例如"1"
+		s, ok := value.(string)
+		if !ok || jsoniter.Unmarshal([]byte(s), ptr.Interface()) != nil {
+			return nil, errors.Errorf("过滤条件[%s]的值不正确: %v", f.name, value)
+		}
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+// bindFilters 校验并转换Paginator.Filters，使用了结构体tag声明过滤条件时，不支持的过滤条件返回错误。
+// 条件组FilterAnd、FilterOr中只能使用已声明的过滤条件
+func (p *PageExecutor) bindFilters(page *Paginator) (map[string]interface{}, error) {
+	filters, _ := preciseNumber(page.Filters, page.numbers).(map[string]interface{})
+	return p.bindFilterMap(filters, p.filterFields != nil)
+}
+
+func (p *PageExecutor) bindFilterMap(filters map[string]interface{}, strict bool) (map[string]interface{}, error) {
+	if filters == nil {
+		return nil, nil
+	}
+	ret := make(map[string]interface{}, len(filters))
+	for k, v := range filters {
+		if k == FilterAnd || k == FilterOr {
+			items := toSlice(v)
+			if items == nil {
+				return nil, errors.Errorf("过滤条件组[%s]的值需为数组", k)
+			}
+			groups := make([]map[string]interface{}, 0, len(items))
+			for _, item := range items {
+				group, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, errors.Errorf("过滤条件组[%s]的元素需为对象", k)
+				}
+				bound, err := p.bindFilterMap(group, true)
+				if err != nil {
+					return nil, err
+				}
+				groups = append(groups, bound)
+			}
+			ret[k] = groups
+			continue
+		}
+
+		if f, ok := p.filterFields[k]; ok {
+			value, err := f.bind(v)
+			if err != nil {
+				return nil, err
+			}
+			ret[k] = value
+			continue
+		}
+		if _, ok := p.criteria[k]; !ok && strict {
+			return nil, errors.Errorf("不支持的过滤条件: %s", k)
+		}
+		ret[k] = v
+	}
+	return ret, nil
+}
+
+// filters 生成${filters}的sql和参数，包括通过结构体tag声明的过滤条件和条件组
+func (p *PageExecutor) filters(db *gorm.DB, values map[string]interface{}) (string, []interface{}, error) {
+	names := append(append([]string{}, p.filterNames...), FilterAnd, FilterOr)
+	return p.buildGroup(db, values, names, " AND ")
+}
+
+func hasFilterGroup(values map[string]interface{}) bool {
+	_, and := values[FilterAnd]
+	_, or := values[FilterOr]
+	return and || or
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,21 +1,44 @@
 package orm
 
 import (
+	"context"
+	"database/sql"
 	"github.com/vuuvv/errors"
 	"go.uber.org/zap"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	"gorm.io/plugin/dbresolver"
 	"log"
 	"os"
+	"strings"
 	"time"
 )
 
+func dialector(typ string, dsn string) gorm.Dialector {
+	switch typ {
+	case "sqlite":
+		if dsn == "" {
+			dsn = ":memory:"
+		}
+		return sqlite.Open(dsn)
+	case "postgres":
+		return postgres.New(postgres.Config{
+			DSN:                  dsn,
+			PreferSimpleProtocol: true,
+		})
+	default:
+		return mysql.Open(dsn)
+	}
+}
+
 func New(config *Config) (db *gorm.DB, err error) {
 	gConfig := &gorm.Config{
 		SkipDefaultTransaction: true,
 		PrepareStmt:            true,
+		DisableAutomaticPing:   true,
 	}
 	if config.Debug {
 		gConfig.Logger = logger.New(log.New(os.Stdout, "\r\n", log.LstdFlags), logger.Config{
@@ -24,18 +47,151 @@ func New(config *Config) (db *gorm.DB, err error) {
 			Colorful:      true,
 		})
 	}
-	switch config.Type {
-	case "postgres":
-		db, err = gorm.Open(postgres.New(postgres.Config{
-			DSN:                  config.Dsn,
-			PreferSimpleProtocol: true,
-		}), gConfig)
-	default:
-		db, err = gorm.Open(mysql.Open(config.Dsn), gConfig)
+	db, err = gorm.Open(dialector(config.Type, config.Dsn), gConfig)
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
+
+	sqlDB, err := db.DB()
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	zap.L().Info("gorm客户端初始化成功", zap.String("dns", config.Dsn))
+	if isMemorySqlite(config) {
+		// 内存数据库每个连接都是独立的数据库，只能使用一个连接并且不能被回收
+		memoryConfig := *config
+		config = &memoryConfig
+		config.MaxOpenConns = 1
+		config.MaxIdleConns = 1
+		config.ConnMaxLifetime = 0
+		config.ConnMaxIdleTime = 0
+	}
+	sqlDB.SetMaxOpenConns(config.MaxOpenConns)
+	if config.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(config.MaxIdleConns)
+	}
+	sqlDB.SetConnMaxLifetime(config.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+
+	ctx := context.Background()
+	if config.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.ConnectTimeout)
+		defer cancel()
+	}
+	if err = sqlDB.PingContext(ctx); err != nil {
+		_ = sqlDB.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	if err = db.Use(&auditPlugin{systemUserId: config.SystemUserId}); err != nil {
+		_ = sqlDB.Close()
+		return nil, errors.WithStack(err)
+	}
+	if err = db.Use(&versionPlugin{}); err != nil {
+		_ = sqlDB.Close()
+		return nil, errors.WithStack(err)
+	}
+	if err = db.Use(&changeLogPlugin{recorder: TableRecorder()}); err != nil {
+		_ = sqlDB.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	if len(config.Replicas) > 0 {
+		replicas := make([]gorm.Dialector, 0, len(config.Replicas))
+		for _, dsn := range config.Replicas {
+			replicas = append(replicas, dialector(config.Type, dsn))
+		}
+		resolver := dbresolver.Register(dbresolver.Config{Replicas: replicas}).
+			SetMaxOpenConns(config.MaxOpenConns).
+			SetConnMaxLifetime(config.ConnMaxLifetime).
+			SetConnMaxIdleTime(config.ConnMaxIdleTime)
+		if config.MaxIdleConns > 0 {
+			resolver.SetMaxIdleConns(config.MaxIdleConns)
+		}
+		pools := &replicaPools{}
+		resolver.Call(func(pool gorm.ConnPool) error {
+			if replica, ok := pool.(*sql.DB); ok && replica != sqlDB {
+				pools.replicas = append(pools.replicas, replica)
+			}
+			return nil
+		})
+		err = db.Use(resolver)
+		if err == nil {
+			err = db.Use(pools)
+		}
+		if err != nil {
+			_ = sqlDB.Close()
+			return nil, errors.WithStack(err)
+		}
+	}
+	zap.L().Info("gorm客户端初始化成功", zap.String("dns", config.Dsn), zap.Int("replicas", len(config.Replicas)))
 	return db, nil
 }
+
+func isMemorySqlite(config *Config) bool {
+	if config.Type != "sqlite" {
+		return false
+	}
+	return config.Dsn == "" || strings.Contains(config.Dsn, ":memory:") || strings.Contains(config.Dsn, "mode=memory")
+}
+
+// Primary 强制使用主库，用于读取后立即更新等不能容忍副本延迟的场景，未配置副本时不影响
+func Primary(db *gorm.DB) *gorm.DB {
+	return db.Clauses(dbresolver.Write)
+}
+
+// replicaPools 记录dbresolver创建的副本连接池，用于健康检测和连接池统计
+type replicaPools struct {
+	replicas []*sql.DB
+}
+
+func (this *replicaPools) Name() string {
+	return "orca:replicas"
+}
+
+func (this *replicaPools) Initialize(db *gorm.DB) error {
+	return nil
+}
+
+func replicasOf(db *gorm.DB) []*sql.DB {
+	if pools, ok := db.Config.Plugins[(&replicaPools{}).Name()].(*replicaPools); ok {
+		return pools.replicas
+	}
+	return nil
+}
+
+// Ping 检查主库和所有副本的连接，用于健康检测
+func Ping(ctx context.Context, db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err = sqlDB.PingContext(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+	for i, replica := range replicasOf(db) {
+		if err = replica.PingContext(ctx); err != nil {
+			return errors.Wrapf(err, "副本%d连接失败: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Stats 返回主库连接池的统计信息
+func Stats(db *gorm.DB) sql.DBStats {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return sql.DBStats{}
+	}
+	return sqlDB.Stats()
+}
+
+// ReplicaStats 按配置顺序返回各副本连接池的统计信息
+func ReplicaStats(db *gorm.DB) []sql.DBStats {
+	replicas := replicasOf(db)
+	ret := make([]sql.DBStats, 0, len(replicas))
+	for _, replica := range replicas {
+		ret = append(ret, replica.Stats())
+	}
+	return ret
+}
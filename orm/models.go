@@ -70,6 +70,20 @@ type Entity struct {
 	DeletedAt soft_delete.DeletedAt `json:"deletedAt"  gorm:"comment:删除时间"`
 }
 
+// Auditable 记录创建人和最后更新人的模型，嵌入Entity即可
+type Auditable interface {
+	SetCreatedBy(value int64)
+	SetUpdatedBy(value int64)
+}
+
+func (e *Entity) SetCreatedBy(value int64) {
+	e.CreatedBy = value
+}
+
+func (e *Entity) SetUpdatedBy(value int64) {
+	e.UpdatedBy = value
+}
+
 type TreeType interface {
 	GetId() int64
 	SetId(value int64)
@@ -17,4 +17,15 @@ const (
 	OP_Contain            string = "CONTAIN"
 	OP_StartsWith         string = "STARTS_WITH"
 	OP_EndsWith           string = "ENDS_WITH"
+	// OP_IContain 不区分大小写的包含，postgres使用ILIKE
+	OP_IContain string = "ICONTAIN"
+	// OP_IsNull 值为false时表示IS NOT NULL
+	OP_IsNull    string = "IS NULL"
+	OP_IsNotNull string = "IS NOT NULL"
+)
+
+// 过滤条件组，值为过滤条件的数组，例如{"$or": [{"name": "a"}, {"code": "b"}]}
+const (
+	FilterAnd = "$and"
+	FilterOr  = "$or"
 )
@@ -2,38 +2,52 @@ package orm
 
 import (
 	"fmt"
+	jsoniter "github.com/json-iterator/go"
 	"github.com/vuuvv/errors"
 	"github.com/vuuvv/orca/utils"
 	"gorm.io/gorm"
+	"reflect"
 	"strings"
 )
 
-type Criteria struct {
-	table string
-	field string
-	op    string
+// SortBy 客户端指定的排序，Field为PageExecutor.Sortable声明的排序名称。
+// 也可以使用字符串，例如"name"表示升序，"-name"表示降序
+type SortBy struct {
+	Field string `json:"field"`
+	Asc   bool   `json:"asc"`
 }
 
-func (c *Criteria) String(db *gorm.DB) string {
-	if c.table == "" {
-		return fmt.Sprintf("%s %s ?", Quote(db, c.field), c.op)
-	}
-	operator := c.op
-	switch operator {
-	case OP_Contain:
-		operator = OP_Like
-	case OP_StartsWith:
-		operator = OP_Like
-	case OP_EndsWith:
-		operator = OP_Like
+func (s *SortBy) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var field string
+		if err := jsoniter.Unmarshal(data, &field); err != nil {
+			return errors.WithStack(err)
+		}
+		s.Asc = !strings.HasPrefix(field, "-")
+		s.Field = strings.TrimPrefix(field, "-")
+		return nil
 	}
-	return fmt.Sprintf("%s.%s %s ?", Quote(db, c.table), Quote(db, c.field), operator)
+	type sortBy SortBy
+	return errors.WithStack(jsoniter.Unmarshal(data, (*sortBy)(s)))
 }
 
-type SortBy struct {
+type sortField struct {
 	table string
 	field string
-	asc   bool
+}
+
+func (f *sortField) column(db *gorm.DB) string {
+	if f.table == "" {
+		return Quote(db, f.field)
+	}
+	return fmt.Sprintf("%s.%s", Quote(db, f.table), Quote(db, f.field))
+}
+
+func (f *sortField) Sql(db *gorm.DB, asc bool) string {
+	if asc {
+		return f.column(db) + " ASC"
+	}
+	return f.column(db) + " DESC"
 }
 
 type Paginator struct {
@@ -44,6 +58,11 @@ type Paginator struct {
 	NoCount   bool                   `json:"noCount"`
 	UseOffset bool                   `json:"useOffset"`
 	Offset    int                    `json:"offset"`
+	// UseCursor 使用游标分页，第一页时设置，之后传入上一次返回的Page.Next或Page.Prev
+	UseCursor bool   `json:"useCursor"`
+	Cursor    string `json:"cursor"`
+	// numbers GetPaginator使用json.Number解析的Filters
+	numbers map[string]interface{}
 }
 
 func (p *Paginator) HasFilter(key string) (ok bool) {
@@ -66,6 +85,10 @@ type Page struct {
 	PageSize int         `json:"pageSize"`
 	Total    int         `json:"total"`
 	Items    interface{} `json:"items"`
+	// Next 游标分页时下一页的游标，没有下一页时为空
+	Next string `json:"next,omitempty"`
+	// Prev 游标分页时上一页的游标，没有上一页时为空
+	Prev string `json:"prev,omitempty"`
 }
 
 type OrderBy struct {
@@ -86,11 +109,14 @@ func (this *OrderBy) Sql(db *gorm.DB) string {
 }
 
 type PageExecutor struct {
-	countSql string
-	sql      string
-	shareSql string
-	orderBy  []*OrderBy
-	criteria map[string]*Criteria
+	countSql     string
+	sql          string
+	shareSql     string
+	orderBy      []*OrderBy
+	criteria     map[string]*Criteria
+	filterFields map[string]*filterField
+	filterNames  []string
+	sortFields   map[string]*sortField
 }
 
 func NewPage() *PageExecutor {
@@ -119,6 +145,34 @@ func (p *PageExecutor) Criteria(name string, table string, field string, op stri
 	return p
 }
 
+// Filters 通过结构体的filter tag声明过滤条件，生效的条件用AND连接后替换模板中的${filters}。
+// 声明后Paginator.Filters中未声明的过滤条件会返回错误，值会按字段类型校验和转换
+func (p *PageExecutor) Filters(dto interface{}) *PageExecutor {
+	fields, err := parseFilterFields(reflect.TypeOf(dto))
+	utils.PanicIf(err)
+	if p.criteria == nil {
+		p.criteria = map[string]*Criteria{}
+	}
+	if p.filterFields == nil {
+		p.filterFields = map[string]*filterField{}
+	}
+	for _, f := range fields {
+		if _, ok := p.filterFields[f.name]; !ok {
+			p.filterNames = append(p.filterNames, f.name)
+		}
+		p.filterFields[f.name] = f
+		p.criteria[f.name] = f.criteria
+	}
+	return p
+}
+
+// CriteriaPath 声明比较json字段中路径上的值的过滤条件，path用.分割，例如spec.color
+func (p *PageExecutor) CriteriaPath(name string, table string, field string, path string, op string) *PageExecutor {
+	p.Criteria(name, table, field, op)
+	p.criteria[name].path = strings.Split(path, ".")
+	return p
+}
+
 func (p *PageExecutor) Join(sql string) *PageExecutor {
 	p.shareSql = sql
 	return p
@@ -133,14 +187,63 @@ func (p *PageExecutor) OrderBy(table string, field string, asc bool) *PageExecut
 	return p
 }
 
+// Sortable 声明客户端可以使用的排序，name为Paginator.Sort中的名称，对应table.field。
+// 客户端的排序优先于OrderBy，未声明的排序返回错误
+func (p *PageExecutor) Sortable(name string, table string, field string) *PageExecutor {
+	if p.sortFields == nil {
+		p.sortFields = map[string]*sortField{}
+	}
+	p.sortFields[name] = &sortField{table: table, field: field}
+	return p
+}
+
+func (p *PageExecutor) orderBySql(db *gorm.DB, page *Paginator) (string, error) {
+	var orderBySql []string
+	for _, s := range page.Sort {
+		f, ok := p.sortFields[s.Field]
+		if !ok {
+			return "", errors.Errorf("不支持的排序字段: %s", s.Field)
+		}
+		orderBySql = append(orderBySql, f.Sql(db, s.Asc))
+	}
+	for _, o := range p.orderBy {
+		orderBySql = append(orderBySql, o.Sql(db))
+	}
+	return strings.Join(orderBySql, ","), nil
+}
+
+// Query 分页查询。Paginator.UseCursor或Cursor不为空时使用游标分页：不查询总数，
+// 模板中需包含${cursor}，排序的最后一个字段需唯一，例如OrderBy("u", "id", true)
 func (p *PageExecutor) Query(db *gorm.DB, page *Paginator, items interface{}) (*Page, error) {
+	filters, err := p.bindFilters(page)
+	if err != nil {
+		return nil, err
+	}
+	orderBy, err := p.orderBySql(db, page)
+	if err != nil {
+		return nil, err
+	}
 	vars := map[string]string{}
+	args := map[string][]interface{}{}
 
-	for k := range page.Filters {
+	for k, v := range filters {
 		if c, ok := p.criteria[k]; ok {
-			vars[k] = c.String(db)
+			vars[k], args[k], err = c.build(db, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.filterFields != nil || hasFilterGroup(filters) {
+		vars[filtersVar], args[filtersVar], err = p.filters(db, filters)
+		if err != nil {
+			return nil, err
 		}
 	}
+	if page.UseCursor || page.Cursor != "" {
+		return p.queryCursor(db, page, items, utils.LineJoin(p.sql, p.shareSql), vars, filters, args)
+	}
+
 	ret := &Page{
 		Page:     page.Page,
 		PageSize: page.PageSize,
@@ -156,7 +259,7 @@ func (p *PageExecutor) Query(db *gorm.DB, page *Paginator, items interface{}) (*
 
 	// 获取数量
 	if !page.NoCount || !page.UseOffset {
-		countSql, values := prepare(utils.LineJoin(p.countSql, p.shareSql), vars, page.Filters, p.criteria)
+		countSql, values := prepare(utils.LineJoin(p.countSql, p.shareSql), vars, filters, args)
 		rows, err := db.Raw(countSql, values...).Rows()
 		if err != nil {
 			return ret, errors.WithStack(err)
@@ -173,12 +276,7 @@ func (p *PageExecutor) Query(db *gorm.DB, page *Paginator, items interface{}) (*
 	}
 
 	// 获取值
-	sql, values := prepare(utils.LineJoin(p.sql, p.shareSql), vars, page.Filters, p.criteria)
-	var orderBySql []string
-	for _, o := range p.orderBy {
-		orderBySql = append(orderBySql, o.Sql(db))
-	}
-	orderBy := strings.Join(orderBySql, ",")
+	sql, values := prepare(utils.LineJoin(p.sql, p.shareSql), vars, filters, args)
 	if orderBy != "" {
 		sql = utils.LineJoin(sql, fmt.Sprintf("ORDER BY %s", orderBy))
 	}
@@ -188,7 +286,6 @@ func (p *PageExecutor) Query(db *gorm.DB, page *Paginator, items interface{}) (*
 	} else {
 		sql = utils.LineJoin(sql, fmt.Sprintf("LIMIT %d offset %d", ret.PageSize, ret.PageSize*(ret.Page-1)))
 	}
-	var err error
 	if len(values) > 0 {
 		err = errors.WithStack(db.Raw(sql, values...).Scan(items).Error)
 	} else {
@@ -0,0 +1,276 @@
+package orm
+
+import (
+	"fmt"
+	"github.com/vuuvv/orca/orm/datatypes"
+	"strings"
+	"testing"
+)
+
+type testMenuQuery struct {
+	Name     string  `json:"name" filter:",table=m,op=CONTAIN"`
+	ParentId int64   `filter:"parentId,table=m"`
+	Ids      []int64 `filter:"ids,table=m,field=id,op=IN"`
+	Ignored  string  `json:"ignored"`
+}
+
+func TestPageFilters(t *testing.T) {
+	db := newTestDB(t, &testMenu{})
+	menus := []*testMenu{{Name: "system"}, {Name: "system user"}, {Name: "order"}}
+	for i, m := range menus {
+		m.ParentId = int64(i % 2)
+		if err := db.Create(m).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	executor := NewPage().
+		Count("select count(*) from t_menu as m").
+		Select("select m.* from t_menu as m").
+		Join("where ${filters}").
+		Filters(&testMenuQuery{}).
+		OrderBy("m", "name", true)
+
+	query := func(filters map[string]interface{}) (*Page, []*testMenu, error) {
+		var items []*testMenu
+		page, err := executor.Query(db, &Paginator{Filters: filters}, &items)
+		return page, items, err
+	}
+
+	page, items, err := query(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 3 || len(items) != 3 {
+		t.Fatalf("expected 3 items, got %d %d", page.Total, len(items))
+	}
+
+	page, items, err = query(map[string]interface{}{"name": "system", "parentId": "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || items[0].Name != "system" {
+		t.Fatalf("unexpected result: %d %v", page.Total, items)
+	}
+
+	_, items, err = query(map[string]interface{}{"ids": []int64{menus[1].GetId(), menus[2].GetId()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Name != "order" {
+		t.Fatalf("unexpected result: %v", items)
+	}
+
+	if _, _, err = query(map[string]interface{}{"ignored": "a"}); err == nil {
+		t.Fatal("expected unknown filter error")
+	}
+	if _, _, err = query(map[string]interface{}{"parentId": "abc"}); err == nil {
+		t.Fatal("expected invalid value error")
+	}
+}
+
+func TestPageSort(t *testing.T) {
+	db := newTestDB(t, &testMenu{})
+	for i, name := range []string{"b", "a", "c"} {
+		m := &testMenu{Name: name}
+		m.ParentId = int64(i % 2)
+		if err := db.Create(m).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	executor := NewPage().
+		Count("select count(*) from t_menu as m").
+		Select("select m.* from t_menu as m").
+		Sortable("name", "m", "name").
+		Sortable("parent", "m", "parent_id").
+		OrderBy("m", "id", true)
+
+	paginator := GetPaginator(`{"sort":[{"field":"parent","asc":false},"-name"]}`)
+	var items []*testMenu
+	_, err := executor.Query(db, paginator, &items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, item := range items {
+		names = append(names, item.Name)
+	}
+	if strings.Join(names, ",") != "a,c,b" {
+		t.Fatalf("unexpected order: %v", names)
+	}
+
+	paginator = GetPaginator(`{"sort":["name; drop table t_menu"]}`)
+	if _, err = executor.Query(db, paginator, &items); err == nil {
+		t.Fatal("expected unknown sort error")
+	}
+}
+
+func TestPageCursor(t *testing.T) {
+	db := newTestDB(t, &testMenu{})
+	for i, name := range []string{"a", "b", "c", "d", "e"} {
+		m := &testMenu{Name: name}
+		m.ParentId = int64(i % 2)
+		if err := db.Create(m).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := func(executor *PageExecutor, paginator *Paginator) (*Page, string) {
+		var items []*testMenu
+		page, err := executor.Query(db, paginator, &items)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, item := range items {
+			names = append(names, item.Name)
+		}
+		return page, strings.Join(names, ",")
+	}
+
+	executor := NewPage().
+		Select("select m.* from t_menu as m").
+		Join("where ${cursor}").
+		Sortable("parent", "m", "parent_id").
+		OrderBy("m", "id", true)
+
+	page, names := query(executor, &Paginator{PageSize: 2, UseCursor: true})
+	if names != "a,b" || page.Next == "" || page.Prev != "" {
+		t.Fatalf("unexpected first page: %s %+v", names, page)
+	}
+	page, names = query(executor, &Paginator{PageSize: 2, Cursor: page.Next})
+	if names != "c,d" || page.Next == "" || page.Prev == "" {
+		t.Fatalf("unexpected second page: %s %+v", names, page)
+	}
+	second := page
+	page, names = query(executor, &Paginator{PageSize: 2, Cursor: page.Next})
+	if names != "e" || page.Next != "" || page.Prev == "" {
+		t.Fatalf("unexpected last page: %s %+v", names, page)
+	}
+	page, names = query(executor, &Paginator{PageSize: 2, Cursor: second.Prev})
+	if names != "a,b" || page.Prev != "" || page.Next == "" {
+		t.Fatalf("unexpected prev page: %s %+v", names, page)
+	}
+
+	// 排序方向不一致时展开为OR条件
+	sort := []SortBy{{Field: "parent", Asc: false}}
+	page, names = query(executor, &Paginator{PageSize: 2, UseCursor: true, Sort: sort})
+	if names != "b,d" {
+		t.Fatalf("unexpected first page: %s", names)
+	}
+	page, names = query(executor, &Paginator{PageSize: 2, Cursor: page.Next, Sort: sort})
+	if names != "a,c" {
+		t.Fatalf("unexpected second page: %s", names)
+	}
+
+	// 排序改变后游标失效
+	var items []*testMenu
+	_, err := executor.Query(db, &Paginator{PageSize: 2, Cursor: page.Next}, &items)
+	if err == nil {
+		t.Fatal("expected cursor mismatch error")
+	}
+}
+
+type testProduct struct {
+	Id
+	Name   string
+	Price  int
+	Remark *string
+	Attrs  datatypes.JSON
+}
+
+func (*testProduct) TableName() string {
+	return "t_product"
+}
+
+type testProductQuery struct {
+	Name   string  `json:"name" filter:",op=ICONTAIN"`
+	Prices []int   `json:"prices" filter:",field=price,op=BETWEEN"`
+	Ids    []int64 `json:"ids" filter:",field=id,op=IN"`
+	Remark bool    `json:"remark" filter:",op=IS NULL"`
+	Color  string  `json:"color" filter:",field=attrs,path=spec.color"`
+	Weight int     `json:"weight" filter:",field=attrs,path=spec.weight,op=>"`
+}
+
+func TestPageOperators(t *testing.T) {
+	db := newTestDB(t, &testProduct{})
+	remark := "remark"
+	products := []*testProduct{
+		{Name: "Apple", Price: 10, Attrs: datatypes.JSON(`{"spec":{"color":"red","weight":3}}`)},
+		{Name: "banana", Price: 20, Remark: &remark, Attrs: datatypes.JSON(`{"spec":{"color":"yellow","weight":1}}`)},
+		{Name: "Pineapple", Price: 30, Attrs: datatypes.JSON(`{"spec":{"color":"yellow","weight":5}}`)},
+	}
+	for _, item := range products {
+		if err := db.Create(item).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	executor := NewPage().
+		Count("select count(*) from t_product").
+		Select("select * from t_product").
+		Join("where ${filters}").
+		Filters(&testProductQuery{}).
+		OrderBy("", "price", true)
+
+	cases := []struct {
+		filters string
+		names   string
+	}{
+		{`{"name": "APPLE"}`, "Apple,Pineapple"},
+		{`{"prices": [15, 30]}`, "banana,Pineapple"},
+		{`{"prices": [null, 20]}`, "Apple,banana"},
+		{`{"ids": [` + fmt.Sprint(products[0].GetId()) + `]}`, "Apple"},
+		{`{"remark": true}`, "Apple,Pineapple"},
+		{`{"remark": false}`, "banana"},
+		{`{"color": "yellow"}`, "banana,Pineapple"},
+		{`{"weight": 2}`, "Apple,Pineapple"},
+		{`{"$or": [{"color": "red"}, {"prices": [25, 35]}]}`, "Apple,Pineapple"},
+		{`{"color": "yellow", "$or": [{"weight": 4}, {"$and": [{"name": "nan"}, {"remark": false}]}]}`, "banana,Pineapple"},
+	}
+	for _, c := range cases {
+		var items []*testProduct
+		paginator := GetPaginator(`{"filters":` + c.filters + `}`)
+		page, err := executor.Query(db, paginator, &items)
+		if err != nil {
+			t.Fatalf("%s: %v", c.filters, err)
+		}
+		var names []string
+		for _, item := range items {
+			names = append(names, item.Name)
+		}
+		if strings.Join(names, ",") != c.names || page.Total != len(items) {
+			t.Fatalf("%s: expected %s, got %v (total %d)", c.filters, c.names, names, page.Total)
+		}
+	}
+
+	var items []*testProduct
+	if _, err := executor.Query(db, GetPaginator(`{"filters":{"$or":[{"unknown":1}]}}`), &items); err == nil {
+		t.Fatal("expected unknown filter error")
+	}
+
+	// Filters中的数字仍为float64
+	paginator := GetPaginator(`{"filters":{"weight":2}}`)
+	if _, ok := paginator.Filters["weight"].(float64); !ok {
+		t.Fatalf("expected float64, got %T", paginator.Filters["weight"])
+	}
+}
+
+func TestCriteriaString(t *testing.T) {
+	db := newTestDB(t)
+	cases := []struct {
+		criteria *Criteria
+		sql      string
+	}{
+		{&Criteria{field: "price", op: OP_Between}, "`price` BETWEEN ? AND ?"},
+		{&Criteria{table: "p", field: "id", op: OP_In}, "`p`.`id` IN ?"},
+		{&Criteria{field: "name", op: OP_Contain}, "`name` LIKE ?"},
+		{&Criteria{field: "remark", op: OP_IsNull}, "`remark` IS NULL"},
+	}
+	for _, c := range cases {
+		if sql := c.criteria.String(db); sql != c.sql {
+			t.Fatalf("expected %s, got %s", c.sql, sql)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+	"github.com/vuuvv/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/plugin/soft_delete"
+	"reflect"
+)
+
+// auditFields 更新时不从表单复制的字段
+var auditFields = []string{"Id", "CreatedBy", "CreatedAt", "UpdatedBy", "UpdatedAt", "Trashed", "DeletedAt", "Version"}
+
+// Repository 模型的通用增删改查，T为模型的指针类型，例如*User。
+// 嵌入Entity的模型由orm.New注册的插件根据ctx中的用户填充CreatedBy、UpdatedBy，删除时软删除。
+// 方法的ctx中有事务时(见Transaction和WithTx)使用该事务
+type Repository[T EntityType] struct {
+	db  *gorm.DB
+	typ reflect.Type
+}
+
+func NewRepository[T EntityType](db *gorm.DB) *Repository[T] {
+	var model T
+	typ := reflect.TypeOf(model)
+	if typ == nil || typ.Kind() != reflect.Ptr {
+		panic("Repository的类型需为指针类型")
+	}
+	return &Repository[T]{db: db, typ: typ.Elem()}
+}
+
+func (r *Repository[T]) new() T {
+	return reflect.New(r.typ).Interface().(T)
+}
+
+// DB 返回ctx中的事务或绑定了ctx的db
+func (r *Repository[T]) DB(ctx context.Context) *gorm.DB {
+	return Conn(ctx, r.db)
+}
+
+// Transaction 在事务中执行fn，fn中使用传入的ctx调用Repository的方法
+func (r *Repository[T]) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return Transaction(ctx, r.db, fn)
+}
+
+func (r *Repository[T]) get(db *gorm.DB, id int64) (T, error) {
+	model := r.new()
+	err := GetByIdRaw(db, model, id)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return model, nil
+}
+
+// Get 根据id获取，不存在时返回错误
+func (r *Repository[T]) Get(ctx context.Context, id int64) (T, error) {
+	return r.get(r.DB(ctx), id)
+}
+
+// Lock 根据id获取并加行锁，需在事务中使用
+func (r *Repository[T]) Lock(ctx context.Context, id int64) (T, error) {
+	return r.get(ForUpdate(r.DB(ctx)), id)
+}
+
+// Find 根据条件查询，条件同gorm的Find，例如Find(ctx, "name = ?", name)
+func (r *Repository[T]) Find(ctx context.Context, conds ...interface{}) ([]T, error) {
+	var ret []T
+	err := r.DB(ctx).Find(&ret, conds...).Error
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return ret, nil
+}
+
+// FindByIds 根据id查询
+func (r *Repository[T]) FindByIds(ctx context.Context, ids ...int64) ([]T, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return r.Find(ctx, ids)
+}
+
+// Create 创建
+func (r *Repository[T]) Create(ctx context.Context, model T) error {
+	return errors.WithStack(r.DB(ctx).Omit(clause.Associations).Create(model).Error)
+}
+
+// Update 用form更新form.GetId()对应的记录，只更新有变化的字段，返回更新后的记录。
+// 创建人、创建时间等审计字段不会从form复制
+func (r *Repository[T]) Update(ctx context.Context, form T, excludeFields ...string) (T, error) {
+	db := r.DB(ctx)
+	model, err := r.get(Primary(db), form.GetId())
+	if err != nil {
+		return model, err
+	}
+	excludes := append(append([]string{}, excludeFields...), auditFields...)
+	fields := NeedUpdateFields(model, form, excludes...)
+	if len(fields) == 0 {
+		return model, nil
+	}
+	err = db.Model(model).Omit(clause.Associations).Select(fields).Updates(form).Error
+	if err != nil {
+		return model, errors.WithStack(err)
+	}
+	return model, invalidateCache(db)
+}
+
+// Updates 更新model的指定字段
+func (r *Repository[T]) Updates(ctx context.Context, model T, fields ...string) error {
+	return Updates(r.DB(ctx), model, fields...)
+}
+
+// Delete 根据id删除，嵌入Entity的模型为软删除
+func (r *Repository[T]) Delete(ctx context.Context, ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	db := r.DB(ctx)
+	err := db.Delete(r.new(), ids).Error
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return invalidateCache(db)
+}
+
+// ForceDelete 根据id物理删除
+func (r *Repository[T]) ForceDelete(ctx context.Context, ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	db := r.DB(ctx)
+	err := db.Unscoped().Delete(r.new(), ids).Error
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return invalidateCache(db)
+}
+
+// Page 分页查询，executor为空时查询整个表，按id倒序，有Trashed软删除字段的模型排除已删除的记录
+func (r *Repository[T]) Page(ctx context.Context, executor *PageExecutor, page *Paginator) (*Page, []T, error) {
+	db := r.DB(ctx)
+	if executor == nil {
+		executor = r.defaultPage(db)
+	}
+	var items []T
+	ret, err := executor.Query(db, page, &items)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ret, items, nil
+}
+
+func (r *Repository[T]) defaultPage(db *gorm.DB) *PageExecutor {
+	model := r.new()
+	table := Quote(db, model.TableName())
+	executor := NewPage().
+		Count(fmt.Sprintf("select count(*) from %s", table)).
+		Select(fmt.Sprintf("select * from %s", table)).
+		OrderBy("", "id", false)
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err == nil {
+		// 只有软删除字段才在查询时过滤已删除的记录
+		if field := stmt.Schema.LookUpField("Trashed"); field != nil && field.FieldType == reflect.TypeOf(soft_delete.DeletedAt(0)) {
+			executor.Join(fmt.Sprintf("where %s = 0", Quote(db, field.DBName)))
+		}
+	}
+	return executor
+}
+
+// Exists 是否存在满足条件的记录
+func (r *Repository[T]) Exists(ctx context.Context, conds ...interface{}) (bool, error) {
+	var count int64
+	db := r.DB(ctx).Model(r.new())
+	if len(conds) > 0 {
+		db = db.Where(conds[0], conds[1:]...)
+	}
+	err := db.Count(&count).Error
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return count > 0, nil
+}
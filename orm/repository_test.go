@@ -0,0 +1,125 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type testUser struct {
+	Id
+	Entity
+	Name  string
+	Email string
+}
+
+func (*testUser) TableName() string {
+	return "t_user"
+}
+
+func (*testUser) TableTitle() string {
+	return "用户"
+}
+
+func TestRepository(t *testing.T) {
+	db := newTestDB(t, &testUser{})
+	repo := NewRepository[*testUser](db)
+	ctx := WithUserId(context.Background(), 7)
+
+	user := &testUser{Name: "a", Email: "a@a.com"}
+	if err := repo.Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if user.CreatedBy != 7 || user.UpdatedBy != 7 {
+		t.Fatalf("expected audit fields, got %d %d", user.CreatedBy, user.UpdatedBy)
+	}
+
+	updated, err := repo.Update(WithUserId(context.Background(), 8), &testUser{Id: Id{Id: user.GetId()}, Name: "b", Email: "a@a.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Name != "b" || updated.CreatedBy != 7 || updated.UpdatedBy != 8 {
+		t.Fatalf("unexpected updated user: %+v", updated)
+	}
+	got, err := repo.Get(ctx, user.GetId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "b" || got.CreatedBy != 7 || got.UpdatedBy != 8 {
+		t.Fatalf("unexpected user: %+v", got)
+	}
+
+	// 事务回滚
+	rollback := errors.New("rollback")
+	err = repo.Transaction(ctx, func(ctx context.Context) error {
+		if err := repo.Create(ctx, &testUser{Name: "c"}); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("expected rollback error, got %v", err)
+	}
+	if exists, _ := repo.Exists(ctx, "name = ?", "c"); exists {
+		t.Fatal("expected transaction to be rolled back")
+	}
+
+	page, items, err := repo.Page(ctx, nil, &Paginator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || len(items) != 1 {
+		t.Fatalf("expected 1 user, got %d", page.Total)
+	}
+
+	if err = repo.Delete(ctx, user.GetId()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.Get(ctx, user.GetId()); err == nil {
+		t.Fatal("expected user to be deleted")
+	}
+	if page, _, _ = repo.Page(ctx, nil, &Paginator{}); page.Total != 0 {
+		t.Fatalf("expected deleted user to be excluded, got %d", page.Total)
+	}
+	var count int64
+	db.Unscoped().Model(&testUser{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("expected soft delete, got %d", count)
+	}
+}
+
+// testNote 有审计字段但没有软删除字段
+type testNote struct {
+	Id
+	CreatedBy int64
+	UpdatedBy int64
+	Content   string
+}
+
+func (*testNote) TableName() string {
+	return "t_note"
+}
+
+func (this *testNote) SetCreatedBy(value int64) {
+	this.CreatedBy = value
+}
+
+func (this *testNote) SetUpdatedBy(value int64) {
+	this.UpdatedBy = value
+}
+
+func TestRepositoryPageWithoutTrashed(t *testing.T) {
+	db := newTestDB(t, &testNote{})
+	repo := NewRepository[*testNote](db)
+	ctx := WithUserId(context.Background(), 7)
+	if err := repo.Create(ctx, &testNote{Content: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	page, items, err := repo.Page(ctx, nil, &Paginator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || len(items) != 1 || items[0].CreatedBy != 7 {
+		t.Fatalf("unexpected page: %d %+v", page.Total, items)
+	}
+}
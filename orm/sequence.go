@@ -1,11 +1,17 @@
 package orm
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
+	"github.com/go-redis/redis/v8"
 	"github.com/vuuvv/errors"
 	"github.com/vuuvv/orca/utils"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"strings"
+	"sync"
 )
 
 type Sequence struct {
@@ -21,46 +27,434 @@ func (*Sequence) TableTitle() string {
 	return "序列号"
 }
 
-type sequenceService struct {
+// SequenceBackend 序列号的存储，db为调用方的连接，Allocate可以使用自己的连接。
+// 改名须随调用方的事务回滚，否则节点的path回滚后序列已经改名，之后会分配出重复的code，
+// 不支持事务的实现使用AfterCommit在提交后改名
+type SequenceBackend interface {
+	// Allocate 分配key的count个序列号，返回的序列号递增
+	Allocate(db *gorm.DB, key string, count int) ([]int, error)
+	// Rename 将key的序列改为newKey，key不存在时不处理
+	Rename(db *gorm.DB, key string, newKey string) error
+	// RenamePrefix 将key为prefix或以prefix:开头的序列的前缀改为newPrefix，用于移动树节点时移动整棵子树的序列
+	RenamePrefix(db *gorm.DB, prefix string, newPrefix string) error
 }
 
-func (s *sequenceService) NextId(db *gorm.DB, key string) (value int, err error) {
-	err = db.Transaction(func(tx *gorm.DB) error {
+// sequenceValue t_sequence中key的当前值，没有记录时返回0，用于切换实现时接续已分配的序列号
+func sequenceValue(db *gorm.DB, key string) (int, error) {
+	seq := &Sequence{}
+	err := db.First(seq, fmt.Sprintf("%s = ?", Quote(db, "key")), key).Error
+	if RecordNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return seq.Value, nil
+}
+
+func sequenceRange(last int, count int) []int {
+	ids := make([]int, count)
+	for i := range ids {
+		ids[i] = last - count + 1 + i
+	}
+	return ids
+}
+
+type dbSequence struct {
+	db *gorm.DB
+}
+
+// DBSequence 使用t_sequence表的序列，加行锁分配。db为空时使用调用方的连接，
+// 预分配时须指定db，避免调用方事务回滚后重复分配已缓存的序列号。改名总是使用调用方的连接
+func DBSequence(db *gorm.DB) SequenceBackend {
+	return &dbSequence{db: db}
+}
+
+func (s *dbSequence) conn(db *gorm.DB) *gorm.DB {
+	if s.db != nil {
+		return s.db.WithContext(db.Statement.Context)
+	}
+	return db
+}
+
+func (s *dbSequence) Allocate(db *gorm.DB, key string, count int) (ids []int, err error) {
+	err = s.conn(db).Transaction(func(tx *gorm.DB) error {
 		seq := &Sequence{}
 
 		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(seq, fmt.Sprintf("%s = ?", Quote(tx, "key")), key).Error
 		if err == gorm.ErrRecordNotFound {
 			// 新的记录则插入
 			seq.Key = key
-			seq.Value = 1
-			err = tx.Save(seq).Error
-			if err != nil {
-				return err
-			}
-			value = 1
-			return nil
+			seq.Value = count
+			ids = sequenceRange(seq.Value, count)
+			return tx.Save(seq).Error
 		}
 		if err != nil {
 			return err
 		}
 		// 旧的记录则更新
-		seq.Value++
-		value = seq.Value
+		seq.Value += count
+		ids = sequenceRange(seq.Value, count)
 		return tx.Model(seq).Update("value", seq.Value).Error
 	})
 	if err != nil {
-		return 0, errors.WithStack(err)
+		return nil, errors.WithStack(err)
+	}
+	return ids, nil
+}
+
+func (s *dbSequence) Rename(db *gorm.DB, key string, newKey string) error {
+	err := db.Model(&Sequence{}).
+		Where(fmt.Sprintf("%s = ?", Quote(db, "key")), key).
+		Update("key", newKey).Error
+	return errors.WithStack(err)
+}
+
+func (s *dbSequence) RenamePrefix(db *gorm.DB, prefix string, newPrefix string) error {
+	key := Quote(db, "key")
+	err := db.Exec(
+		fmt.Sprintf(
+			"UPDATE %s SET %s=%s WHERE %s=? OR %s LIKE ? ESCAPE '!'",
+			Quote(db, (&Sequence{}).TableName()), key, concatSql(db, "?", "SUBSTR("+key+", ?)"), key, key,
+		),
+		newPrefix, len(prefix)+1, prefix, likePrefix(prefix+":"),
+	).Error
+	return errors.WithStack(err)
+}
+
+type redisSequence struct {
+	client *redis.Client
+}
+
+const redisSequencePrefix = "orca:sequence:"
+
+// RedisSequence 使用redis INCRBY分配，key不存在时从t_sequence接续。
+// redis不能随数据库事务回滚，改名在调用方的事务提交后执行，见AfterCommit
+func RedisSequence(client *redis.Client) SequenceBackend {
+	return &redisSequence{client: client}
+}
+
+func (s *redisSequence) Allocate(db *gorm.DB, key string, count int) ([]int, error) {
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	redisKey := redisSequencePrefix + key
+	exists, err := s.client.Exists(ctx, redisKey).Result()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if exists == 0 {
+		value, err := sequenceValue(db, key)
+		if err != nil {
+			return nil, err
+		}
+		// 多个实例同时初始化时只有一个生效
+		if err = s.client.SetNX(ctx, redisKey, value, 0).Err(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	last, err := s.client.IncrBy(ctx, redisKey, int64(count)).Result()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return sequenceRange(int(last), count), nil
+}
+
+func (s *redisSequence) Rename(db *gorm.DB, key string, newKey string) error {
+	return AfterCommit(db, func() error {
+		return s.rename(context.Background(), key, newKey)
+	})
+}
+
+func (s *redisSequence) RenamePrefix(db *gorm.DB, prefix string, newPrefix string) error {
+	return AfterCommit(db, func() error {
+		ctx := context.Background()
+		if err := s.rename(ctx, prefix, newPrefix); err != nil {
+			return err
+		}
+		match := redisGlobEscaper.Replace(redisSequencePrefix+prefix) + ":*"
+		iter := s.client.Scan(ctx, 0, match, 100).Iterator()
+		var keys []string
+		for iter.Next(ctx) {
+			keys = append(keys, strings.TrimPrefix(iter.Val(), redisSequencePrefix))
+		}
+		if err := iter.Err(); err != nil {
+			return errors.WithStack(err)
+		}
+		for _, key := range keys {
+			if err := s.rename(ctx, key, newPrefix+key[len(prefix):]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// redisGlobEscaper 转义SCAN MATCH中的通配符
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+func (s *redisSequence) rename(ctx context.Context, key string, newKey string) error {
+	exists, err := s.client.Exists(ctx, redisSequencePrefix+key).Result()
+	if err != nil || exists == 0 {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(s.client.Rename(ctx, redisSequencePrefix+key, redisSequencePrefix+newKey).Err())
+}
+
+type postgresSequence struct {
+	db *gorm.DB
+}
+
+// PostgresSequence 使用postgres的SEQUENCE分配，每个key一个SEQUENCE，不存在时创建并从t_sequence接续，
+// key保存在SEQUENCE的注释中。nextval不受事务回滚影响，db为空时使用调用方的连接，但创建SEQUENCE的事务回滚时会重新创建。
+// 改名总是使用调用方的连接，随调用方的事务回滚
+func PostgresSequence(db *gorm.DB) SequenceBackend {
+	return &postgresSequence{db: db}
+}
+
+func (s *postgresSequence) conn(db *gorm.DB) *gorm.DB {
+	if s.db != nil {
+		return s.db.WithContext(db.Statement.Context)
+	}
+	return db
+}
+
+// sequenceName key中可能包含任意字符且长度超过标识符限制，使用md5作为名称
+func (s *postgresSequence) sequenceName(key string) string {
+	sum := md5.Sum([]byte(key))
+	return "seq_" + hex.EncodeToString(sum[:])
+}
+
+func (s *postgresSequence) Allocate(db *gorm.DB, key string, count int) ([]int, error) {
+	conn := s.conn(db)
+	name := s.sequenceName(key)
+	var exists int64
+	err := conn.Raw("SELECT COUNT(*) FROM pg_class WHERE relkind = 'S' AND relname = ?", name).Scan(&exists).Error
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if exists == 0 {
+		value, err := sequenceValue(conn, key)
+		if err != nil {
+			return nil, err
+		}
+		err = conn.Exec(fmt.Sprintf("CREATE SEQUENCE IF NOT EXISTS %s START WITH %d", Quote(conn, name), value+1)).Error
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		err = conn.Exec(fmt.Sprintf("COMMENT ON SEQUENCE %s IS %s", Quote(conn, name), quoteLiteral(key))).Error
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	var ids []int
+	err = conn.Raw("SELECT nextval(?) FROM generate_series(1, ?) ORDER BY 1", name, count).Scan(&ids).Error
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return ids, nil
+}
+
+func (s *postgresSequence) Rename(db *gorm.DB, key string, newKey string) error {
+	keys, err := s.keys(db, key, false)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err = s.rename(db, k, newKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *postgresSequence) RenamePrefix(db *gorm.DB, prefix string, newPrefix string) error {
+	keys, err := s.keys(db, prefix, true)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = s.rename(db, key, newPrefix+key[len(prefix):]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keys 已创建SEQUENCE的key，children为true时包括以key:开头的key
+func (s *postgresSequence) keys(db *gorm.DB, key string, children bool) ([]string, error) {
+	query := "SELECT d.description FROM pg_class c JOIN pg_description d ON d.objoid = c.oid AND d.classoid = 'pg_class'::regclass " +
+		"WHERE c.relkind = 'S' AND (d.description = ?"
+	args := []interface{}{key}
+	if children {
+		query += " OR d.description LIKE ? ESCAPE '!'"
+		args = append(args, likePrefix(key+":"))
+	}
+	var keys []string
+	err := db.Raw(query+")", args...).Scan(&keys).Error
+	return keys, errors.WithStack(err)
+}
+
+func (s *postgresSequence) rename(db *gorm.DB, key string, newKey string) error {
+	name := s.sequenceName(newKey)
+	err := db.Exec(fmt.Sprintf("ALTER SEQUENCE %s RENAME TO %s", Quote(db, s.sequenceName(key)), Quote(db, name))).Error
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(db.Exec(fmt.Sprintf("COMMENT ON SEQUENCE %s IS %s", Quote(db, name), quoteLiteral(newKey))).Error)
+}
+
+// quoteLiteral postgres的字符串常量，COMMENT等语句不支持参数
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+type sequenceBlock struct {
+	mu  sync.Mutex
+	ids []int
+}
+
+// CodeCodec 树节点code与序列号的转换，见utils.Base64LikeCodec和utils.SortableCodec
+type CodeCodec interface {
+	Encode(value int) (string, error)
+	Decode(code string) (int, error)
+}
+
+type sequenceService struct {
+	mu        sync.Mutex
+	backend   SequenceBackend
+	blockSize int
+	blocks    map[string]*sequenceBlock
+	codec     CodeCodec
+}
+
+// UseSequenceBackend 设置SequenceService使用的实现，blockSize大于1时每次预分配blockSize个序列号缓存在进程内，
+// 未使用的序列号在重启后丢弃
+func UseSequenceBackend(backend SequenceBackend, blockSize int) {
+	SequenceService.mu.Lock()
+	defer SequenceService.mu.Unlock()
+	SequenceService.backend = backend
+	SequenceService.blockSize = blockSize
+	SequenceService.blocks = map[string]*sequenceBlock{}
+}
+
+// UseCodeCodec 设置SequenceService生成code的编码，默认为utils.Base64LikeCodec。
+// 已有数据时需先用ReencodeTree转换，否则新旧code可能重复
+func UseCodeCodec(codec CodeCodec) {
+	SequenceService.mu.Lock()
+	defer SequenceService.mu.Unlock()
+	SequenceService.codec = codec
+}
+
+// Codec 当前生成code使用的编码
+func (s *sequenceService) Codec() CodeCodec {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.codec
+}
+
+func (s *sequenceService) block(key string) *sequenceBlock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.blocks[key]
+	if !ok {
+		b = &sequenceBlock{}
+		s.blocks[key] = b
 	}
-	return value, nil
+	return b
+}
+
+func (s *sequenceService) NextId(db *gorm.DB, key string) (value int, err error) {
+	ids, err := s.NextIds(db, key, 1)
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+// NextIds 分配count个递增的序列号
+func (s *sequenceService) NextIds(db *gorm.DB, key string, count int) ([]int, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+	s.mu.Lock()
+	backend, blockSize := s.backend, s.blockSize
+	s.mu.Unlock()
+	if blockSize <= 1 {
+		return backend.Allocate(db, key, count)
+	}
+
+	b := s.block(key)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.ids) < count {
+		size := blockSize
+		if count-len(b.ids) > size {
+			size = count - len(b.ids)
+		}
+		ids, err := backend.Allocate(db, key, size)
+		if err != nil {
+			return nil, err
+		}
+		b.ids = append(b.ids, ids...)
+	}
+	ids := append([]int(nil), b.ids[:count]...)
+	b.ids = b.ids[count:]
+	return ids, nil
 }
 
 func (s *sequenceService) NextCode(db *gorm.DB, key string) (code string, err error) {
-	id, err := s.NextId(db, key)
-	code, err = utils.EncodeIntToBase64Like(id)
+	codes, err := s.NextCodes(db, key, 1)
 	if err != nil {
-		return "", errors.WithStack(err)
+		return "", err
+	}
+	return codes[0], nil
+}
+
+// NextCodes 分配count个code，用于批量创建树节点
+func (s *sequenceService) NextCodes(db *gorm.DB, key string, count int) ([]string, error) {
+	ids, err := s.NextIds(db, key, count)
+	if err != nil {
+		return nil, err
+	}
+	codec := s.Codec()
+	codes := make([]string, 0, len(ids))
+	for _, id := range ids {
+		code, err := codec.Encode(id)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		codes = append(codes, code)
 	}
-	return code, nil
+	return codes, nil
 }
 
-var SequenceService = &sequenceService{}
+// Rename 将key的序列改为newKey，并丢弃key在进程内缓存的序列号
+func (s *sequenceService) Rename(db *gorm.DB, key string, newKey string) error {
+	s.mu.Lock()
+	backend := s.backend
+	delete(s.blocks, key)
+	s.mu.Unlock()
+	return backend.Rename(db, key, newKey)
+}
+
+// RenamePrefix 将prefix及以prefix:开头的序列的前缀改为newPrefix，并丢弃这些key在进程内缓存的序列号
+func (s *sequenceService) RenamePrefix(db *gorm.DB, prefix string, newPrefix string) error {
+	s.mu.Lock()
+	backend := s.backend
+	for key := range s.blocks {
+		if key == prefix || strings.HasPrefix(key, prefix+":") {
+			delete(s.blocks, key)
+		}
+	}
+	s.mu.Unlock()
+	return backend.RenamePrefix(db, prefix, newPrefix)
+}
+
+var SequenceService = &sequenceService{
+	backend: DBSequence(nil),
+	blocks:  map[string]*sequenceBlock{},
+	codec:   utils.Base64LikeCodec{},
+}
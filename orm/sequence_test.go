@@ -0,0 +1,115 @@
+package orm
+
+import (
+	"github.com/vuuvv/errors"
+	"gorm.io/gorm"
+	"reflect"
+	"testing"
+)
+
+func TestSequenceBlock(t *testing.T) {
+	db := newTestDB(t, &testMenu{})
+	UseSequenceBackend(DBSequence(db), 10)
+	t.Cleanup(func() {
+		UseSequenceBackend(DBSequence(nil), 0)
+	})
+
+	ids, err := SequenceService.NextIds(db, "k", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int{1, 2, 3}) {
+		t.Fatalf("unexpected ids: %v", ids)
+	}
+	if value, _ := sequenceValue(db, "k"); value != 10 {
+		t.Fatalf("expected block of 10 to be allocated, got %d", value)
+	}
+	ids, err = SequenceService.NextIds(db, "k", 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids[0] != 4 || ids[11] != 15 {
+		t.Fatalf("unexpected ids: %v", ids)
+	}
+	if value, _ := sequenceValue(db, "k"); value != 20 {
+		t.Fatalf("expected 20, got %d", value)
+	}
+
+	if err = SequenceService.Rename(db, "k", "k2"); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := sequenceValue(db, "k2"); value != 20 {
+		t.Fatalf("expected renamed sequence, got %d", value)
+	}
+	if id, _ := SequenceService.NextId(db, "k2"); id != 21 {
+		t.Fatalf("expected 21, got %d", id)
+	}
+
+	// 内存数据库只有一个连接，DBSequence指定了db时不能在事务中分配
+	root := &testMenu{Name: "root"}
+	if err = CreateTree(db, root, nil); err != nil {
+		t.Fatal(err)
+	}
+	children := []TreeType{&testMenu{Name: "a"}, &testMenu{Name: "b"}, &testMenu{Name: "c"}}
+	if err = CreateTrees(db, root, children...); err != nil {
+		t.Fatal(err)
+	}
+	count, err := CountDescendants(db, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("expected 3 children, got %d", count)
+	}
+	for _, child := range children {
+		if child.GetParentId() != root.GetId() || child.GetPath() != root.Path+":"+child.GetCode() {
+			t.Fatalf("unexpected child: %+v", child)
+		}
+	}
+}
+
+func TestMoveTreeRollback(t *testing.T) {
+	db := newTestDB(t, &testMenu{})
+	UseSequenceBackend(DBSequence(db), 10)
+	t.Cleanup(func() {
+		UseSequenceBackend(DBSequence(nil), 0)
+	})
+
+	a := &testMenu{Name: "a"}
+	b := &testMenu{Name: "b"}
+	a1 := &testMenu{Name: "a1"}
+	b1 := &testMenu{Name: "b1"}
+	for _, item := range []struct{ node, parent *testMenu }{{a, nil}, {b, nil}, {a1, a}, {b1, b}} {
+		var parent TreeType
+		if item.parent != nil {
+			item.node.ParentId = item.parent.GetId()
+			parent = item.parent
+		}
+		if err := CreateTree(db, item.node, parent); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 新上级b的序列号已经预分配，事务中不需要另外的连接；改名在事务中执行，随事务回滚
+	form := &testMenu{Name: "a"}
+	form.Id = a.Id
+	form.ParentId = b.GetId()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := UpdateTree(tx, &testMenu{}, form); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	if err == nil || err.Error() != "rollback" {
+		t.Fatalf("expected rollback, got %v", err)
+	}
+
+	a2 := &testMenu{Name: "a2"}
+	a2.ParentId = a.GetId()
+	if err = CreateTree(db, a2, a); err != nil {
+		t.Fatal(err)
+	}
+	if a2.Code == a1.Code {
+		t.Fatalf("expected a new code after rollback, got %s", a2.Code)
+	}
+}
@@ -0,0 +1,205 @@
+package orm
+
+import (
+	"bytes"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/orca/serialize"
+	"gorm.io/gorm"
+	"reflect"
+	"strings"
+)
+
+// subtreeScope path等于path或以"path:"开头的节点，即节点本身及其所有子孙节点
+func subtreeScope(path string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("path = ? OR path LIKE ? ESCAPE '!'", path, likePrefix(path+":"))
+	}
+}
+
+// descendantsScope 所有子孙节点，不包括节点本身
+func descendantsScope(path string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("path LIKE ? ESCAPE '!'", likePrefix(path+":"))
+	}
+}
+
+// ancestorPaths 祖先节点的path，从根节点开始
+func ancestorPaths(path string) []string {
+	codes := strings.Split(path, ":")
+	paths := make([]string, 0, len(codes)-1)
+	for i := 1; i < len(codes); i++ {
+		paths = append(paths, strings.Join(codes[:i], ":"))
+	}
+	return paths
+}
+
+// Subtree 查询节点及其所有子孙节点，按path排序，T为模型的指针类型
+func Subtree[T TreeType](db *gorm.DB, node TreeType) ([]T, error) {
+	var ret []T
+	err := db.Scopes(subtreeScope(node.GetPath())).Order("path").Find(&ret).Error
+	return ret, errors.WithStack(err)
+}
+
+// Descendants 查询节点的所有子孙节点，不包括节点本身，按path排序
+func Descendants[T TreeType](db *gorm.DB, node TreeType) ([]T, error) {
+	var ret []T
+	err := db.Scopes(descendantsScope(node.GetPath())).Order("path").Find(&ret).Error
+	return ret, errors.WithStack(err)
+}
+
+// Ancestors 查询节点的所有祖先节点，从根节点开始
+func Ancestors[T TreeType](db *gorm.DB, node TreeType) ([]T, error) {
+	paths := ancestorPaths(node.GetPath())
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	var ret []T
+	err := db.Where("path IN ?", paths).Order("path").Find(&ret).Error
+	return ret, errors.WithStack(err)
+}
+
+// Children 查询直接子节点，parentId为0时查询根节点
+func Children[T TreeType](db *gorm.DB, parentId int64) ([]T, error) {
+	var ret []T
+	err := db.Where("parent_id = ?", parentId).Order("path").Find(&ret).Error
+	return ret, errors.WithStack(err)
+}
+
+// CountDescendants 统计子孙节点的数量，不包括节点本身
+func CountDescendants(db *gorm.DB, node TreeType) (count int64, err error) {
+	model := reflect.New(reflect.TypeOf(node).Elem()).Interface()
+	err = db.Model(model).Scopes(descendantsScope(node.GetPath())).Count(&count).Error
+	return count, errors.WithStack(err)
+}
+
+// TreeNode 嵌套的树节点，序列化为节点本身的字段加上children
+type TreeNode[T TreeType] struct {
+	Node     T
+	Children []*TreeNode[T]
+}
+
+func (n *TreeNode[T]) MarshalJSON() ([]byte, error) {
+	node, err := serialize.JsonStringifyBytes(n.Node)
+	if err != nil {
+		return nil, err
+	}
+	children := n.Children
+	if children == nil {
+		children = []*TreeNode[T]{}
+	}
+	body, err := serialize.JsonStringifyBytes(children)
+	if err != nil {
+		return nil, err
+	}
+
+	node = bytes.TrimRight(node, " \n")
+	if len(node) < 2 || node[len(node)-1] != '}' {
+		return nil, errors.Errorf("树节点须序列化为json对象: %s", node)
+	}
+	buf := bytes.Buffer{}
+	buf.Write(node[:len(node)-1])
+	if len(bytes.TrimSpace(node[1:len(node)-1])) > 0 {
+		buf.WriteByte(',')
+	}
+	buf.WriteString(`"children":`)
+	buf.Write(body)
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// BuildTree 将节点列表组装为嵌套的树，上级不在列表中的节点作为根节点，同级节点保持列表中的顺序
+func BuildTree[T TreeType](nodes []T) []*TreeNode[T] {
+	treeNodes := make(map[int64]*TreeNode[T], len(nodes))
+	for _, node := range nodes {
+		treeNodes[node.GetId()] = &TreeNode[T]{Node: node}
+	}
+	var roots []*TreeNode[T]
+	for _, node := range nodes {
+		current := treeNodes[node.GetId()]
+		if parent, ok := treeNodes[node.GetParentId()]; ok && node.GetParentId() != node.GetId() {
+			parent.Children = append(parent.Children, current)
+		} else {
+			roots = append(roots, current)
+		}
+	}
+	return roots
+}
+
+// LoadTree 查询并组装嵌套的树，root为空时查询整个表，否则查询root及其子孙节点
+func LoadTree[T TreeType](db *gorm.DB, root TreeType) ([]*TreeNode[T], error) {
+	var nodes []T
+	var err error
+	if root == nil || reflect.ValueOf(root).IsNil() {
+		err = errors.WithStack(db.Order("path").Find(&nodes).Error)
+	} else {
+		nodes, err = Subtree[T](db, root)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return BuildTree(nodes), nil
+}
+
+// ReencodeTree 将表中所有节点的code和path从from编码转换为to编码，并将分配子节点code的序列一起改名，须放入事务中。
+// 转换完成后使用UseCodeCodec(to)，转换期间不能创建或移动节点
+func ReencodeTree(db *gorm.DB, model TreeType, from CodeCodec, to CodeCodec) error {
+	table := model.TableName()
+	var rows []*Tree
+	// Tree没有删除标记，已删除的节点也会转换
+	err := Primary(db).Table(table).Select("id", "code", "path").Find(&rows).Error
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	codes := map[string]string{}
+	reencode := func(code string) (string, error) {
+		if ret, ok := codes[code]; ok {
+			return ret, nil
+		}
+		value, err := from.Decode(code)
+		if err != nil {
+			return "", errors.Wrapf(err, "%s的code[%s]无法解析: %v", model.TableTitle(), code, err)
+		}
+		ret, err := to.Encode(value)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		codes[code] = ret
+		return ret, nil
+	}
+
+	renames := map[string]string{}
+	for _, row := range rows {
+		parts := strings.Split(row.Path, ":")
+		for i, part := range parts {
+			if parts[i], err = reencode(part); err != nil {
+				return err
+			}
+		}
+		code, err := reencode(row.Code)
+		if err != nil {
+			return err
+		}
+		path := strings.Join(parts, ":")
+		err = db.Table(table).Where("id = ?", row.GetId()).Updates(map[string]interface{}{"code": code, "path": path}).Error
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if path != row.Path {
+			renames[table+":"+row.Path] = table + ":" + path
+		}
+	}
+
+	// 新旧key可能重复，先改为临时的key
+	for oldKey, newKey := range renames {
+		if err = SequenceService.Rename(db, oldKey, "#"+newKey); err != nil {
+			return err
+		}
+	}
+	for _, newKey := range renames {
+		if err = SequenceService.Rename(db, "#"+newKey, newKey); err != nil {
+			return err
+		}
+	}
+	return invalidateCache(db)
+}
@@ -0,0 +1,134 @@
+package orm
+
+import (
+	"github.com/vuuvv/orca/serialize"
+	"github.com/vuuvv/orca/utils"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestTreeQueries(t *testing.T) {
+	db := newTestDB(t, &testMenu{})
+	create := func(name string, parent *testMenu) *testMenu {
+		menu := &testMenu{Name: name}
+		var parentNode TreeType
+		if parent != nil {
+			menu.ParentId = parent.GetId()
+			parentNode = parent
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return CreateTree(tx, menu, parentNode)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return menu
+	}
+	names := func(menus []*testMenu) (ret []string) {
+		for _, m := range menus {
+			ret = append(ret, m.Name)
+		}
+		return ret
+	}
+
+	a := create("a", nil)
+	b := create("b", a)
+	c := create("c", b)
+	create("d", a)
+	create("e", nil)
+
+	subtree, err := Subtree[*testMenu](db, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := serialize.MustJsonStringify(names(subtree)); got != `["b","c"]` {
+		t.Fatalf("unexpected subtree: %s", got)
+	}
+	ancestors, err := Ancestors[*testMenu](db, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := serialize.MustJsonStringify(names(ancestors)); got != `["a","b"]` {
+		t.Fatalf("unexpected ancestors: %s", got)
+	}
+	children, err := Children[*testMenu](db, a.GetId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := serialize.MustJsonStringify(names(children)); got != `["b","d"]` {
+		t.Fatalf("unexpected children: %s", got)
+	}
+	count, err := CountDescendants(db, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Fatalf("expected 3 descendants, got %d", count)
+	}
+
+	tree, err := LoadTree[*testMenu](db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree) != 2 || len(tree[0].Children) != 2 || len(tree[0].Children[0].Children) != 1 {
+		t.Fatalf("unexpected tree: %s", serialize.MustJsonStringify(tree))
+	}
+	body := serialize.MustJsonStringify(tree[0].Children[0])
+	expected := serialize.MustJsonParse[map[string]interface{}](body)
+	if (*expected)["Name"] != "b" || len((*expected)["children"].([]interface{})) != 1 {
+		t.Fatalf("unexpected json: %s", body)
+	}
+}
+
+func TestReencodeTree(t *testing.T) {
+	db := newTestDB(t, &testMenu{})
+	sortable, err := utils.NewSortableCodec("", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		UseCodeCodec(utils.Base64LikeCodec{})
+	})
+	create := func(name string, parent *testMenu) *testMenu {
+		menu := &testMenu{Name: name}
+		var parentNode TreeType
+		if parent != nil {
+			menu.ParentId = parent.GetId()
+			parentNode = parent
+		}
+		if err := CreateTree(db, menu, parentNode); err != nil {
+			t.Fatal(err)
+		}
+		return menu
+	}
+	a := create("a", nil)
+	b := create("b", a)
+	create("c", b)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return ReencodeTree(tx, &testMenu{}, utils.Base64LikeCodec{}, sortable)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseCodeCodec(sortable)
+
+	if err = db.First(b, b.GetId()).Error; err != nil {
+		t.Fatal(err)
+	}
+	if b.Code != "101" || b.Path != "101:101" {
+		t.Fatalf("unexpected reencoded node: %+v", b)
+	}
+	// 序列跟随改名，新的子节点接续原来的序列号
+	d := create("d", b)
+	if d.Code != "102" {
+		t.Fatalf("expected code 102, got %s", d.Code)
+	}
+	subtree, err := Subtree[*testMenu](db, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subtree) != 3 || subtree[1].Name != "c" || subtree[2].Name != "d" {
+		t.Fatalf("unexpected subtree: %s", serialize.MustJsonStringify(subtree))
+	}
+}
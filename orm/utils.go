@@ -31,20 +31,15 @@ func Quote(db *gorm.DB, val string) string {
 	return buf.String()
 }
 
-func prepare(template string, vars map[string]string, valueMap map[string]interface{}, criteria map[string]*Criteria) (sql string, values []interface{}) {
+// prepare 替换模板中的变量，args为过滤条件和${filters}等变量的参数
+func prepare(template string, vars map[string]string, valueMap map[string]interface{}, args map[string][]interface{}) (sql string, values []interface{}) {
 	sql, names := replacer.New(template).Replace(vars)
 	for _, v := range names {
-		val := valueMap[v]
-		if c, ok := criteria[v]; ok {
-			switch c.op {
-			case OP_Contain:
-				val = fmt.Sprintf("%%%v%%", val)
-			case OP_StartsWith:
-				val = fmt.Sprintf("%v%%", val)
-			case OP_EndsWith:
-				val = fmt.Sprintf("%%%v", val)
-			}
+		if a, ok := args[v]; ok {
+			values = append(values, a...)
+			continue
 		}
+		val := valueMap[v]
 		if val == true {
 			val = 1
 		} else if val == false {
@@ -56,6 +51,8 @@ func prepare(template string, vars map[string]string, valueMap map[string]interf
 	return sql, values
 }
 
+var paginatorJson = jsoniter.Config{UseNumber: true}.Froze()
+
 func GetPaginator(q string) *Paginator {
 	if q == "" {
 		return &Paginator{
@@ -65,6 +62,12 @@ func GetPaginator(q string) *Paginator {
 	}
 	paginator := &Paginator{}
 	utils.PanicIf(jsoniter.Unmarshal([]byte(q), paginator))
+	// 另外保留过滤条件中数字的原文用于生成sql，避免雪花算法生成的id丢失精度
+	numbers := &struct {
+		Filters map[string]interface{} `json:"filters"`
+	}{}
+	utils.PanicIf(paginatorJson.Unmarshal([]byte(q), numbers))
+	paginator.numbers = numbers.Filters
 	return paginator
 }
 
@@ -256,11 +259,14 @@ func Updates(db *gorm.DB, model interface{}, fields ...string) (err error) {
 	}
 
 	err = db.Model(model).Omit(clause.Associations).Updates(fMap).Error
-	return errors.WithStack(err)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return invalidateCache(db)
 }
 
 func Update(db *gorm.DB, model EntityType, form EntityType, excludeFields ...string) (err error) {
-	err = db.First(model, form.GetId()).Error
+	err = Primary(db).First(model, form.GetId()).Error
 	if err != nil {
 		if utils.RecordNotFound(err) {
 			return errors.Wrapf(err, fmt.Sprintf("%s不存在", model.TableTitle()))
@@ -277,6 +283,7 @@ func Update(db *gorm.DB, model EntityType, form EntityType, excludeFields ...str
 		if err != nil {
 			return errors.WithStack(err)
 		}
+		return invalidateCache(db)
 	}
 	return nil
 }
@@ -296,14 +303,42 @@ func CreateTree(db *gorm.DB, model TreeType, parent TreeType) (err error) {
 		if err != nil {
 			return errors.WithStack(err)
 		}
+		model.SetCode(code)
 		model.SetPath(fmt.Sprintf("%s:%s", parent.GetPath(), code))
 	}
 	return errors.WithStack(db.Create(model).Error)
 }
 
-// UpdateTree 须放入事务中
+// CreateTrees 在同一个上级下批量创建节点，一次分配所有code，须放入事务中
+func CreateTrees(db *gorm.DB, parent TreeType, models ...TreeType) (err error) {
+	if len(models) == 0 {
+		return nil
+	}
+	key := models[0].TableName()
+	if parent != nil && !parent.IsNull() {
+		key = fmt.Sprintf("%s:%s", key, parent.GetPath())
+	}
+	codes, err := SequenceService.NextCodes(db, key, len(models))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	rows := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(models[0])), 0, len(models))
+	for i, model := range models {
+		model.SetCode(codes[i])
+		if parent != nil && !parent.IsNull() {
+			model.SetParentId(parent.GetId())
+			model.SetPath(fmt.Sprintf("%s:%s", parent.GetPath(), codes[i]))
+		} else {
+			model.SetPath(codes[i])
+		}
+		rows = reflect.Append(rows, reflect.ValueOf(model))
+	}
+	return errors.WithStack(db.Create(rows.Interface()).Error)
+}
+
+// UpdateTree 须放入事务中。上级改变时移动节点：在新的上级下重新分配code，并更新节点和所有子孙节点的path
 func UpdateTree(db *gorm.DB, model TreeType, form TreeType) (err error) {
-	err = GetByIdRaw(db, model, form.GetId())
+	err = GetByIdRaw(Primary(db), model, form.GetId())
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -313,52 +348,102 @@ func UpdateTree(db *gorm.DB, model TreeType, form TreeType) (err error) {
 	}
 
 	parentChanged := model.GetParentId() != form.GetParentId()
-	if parentChanged {
-		return errors.Errorf("不可更改[上级%s]", model.TableTitle())
-	}
 	oldPath := model.GetPath()
 	fields := NeedUpdateFields(model, form, "Code", "Path", "Tree")
-	if len(fields) > 0 {
-		if parentChanged {
-			if form.GetParentId() != 0 {
-				parent := &Tree{}
-				err = db.Table(model.TableName()).
-					Where("id=?", form.GetParentId()).
-					Select("code", "path").
-					First(parent).Error
-				if utils.RecordNotFound(err) {
-					return errors.Wrapf(err, model.TableTitle()+"不存在："+reflect.TypeOf(model).Name())
-				}
-				if err != nil {
-					return errors.WithStack(err)
-				}
-				form.SetPath(fmt.Sprintf("%s:%s", parent.GetPath(), model.GetCode()))
-			} else {
-				form.SetPath(model.GetCode())
-			}
-			fields = append(fields, "Path")
-		}
-		err = copier.Copy(model, form)
+	if len(fields) == 0 {
+		return nil
+	}
+	if parentChanged {
+		code, path, err := moveTreePath(db, model, form.GetParentId())
 		if err != nil {
-			return errors.WithStack(err)
+			return err
 		}
-		err = db.Model(model).Select(fields).Updates(model).Error
+		form.SetCode(code)
+		form.SetPath(path)
+		fields = append(fields, "Code", "Path")
+	} else {
+		form.SetCode(model.GetCode())
+		form.SetPath(oldPath)
+	}
+
+	err = copier.Copy(model, form)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = db.Model(model).Select(fields).Updates(model).Error
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if parentChanged {
+		err = rewriteTreePath(db, model.TableName(), oldPath, model.GetPath())
 		if err != nil {
-			return errors.WithStack(err)
+			return err
 		}
+	}
+	return invalidateCache(db)
+}
 
-		if parentChanged {
-			err := db.Exec(
-				fmt.Sprintf("update %s set path=REGEXP_REPLACE(path, ?, ?) where path like ?", Quote(db, model.TableName())),
-				"^"+oldPath+":", model.GetPath()+":",
-				model.GetPath()+":%",
-			).Error
-			if err != nil {
-				return errors.WithStack(err)
-			}
+// moveTreePath 移动节点到parentId下时的新code和path，不可移动到自己的子孙节点下
+func moveTreePath(db *gorm.DB, model TreeType, parentId int64) (code string, path string, err error) {
+	key := model.TableName()
+	parentPath := ""
+	if parentId != 0 {
+		parent := &Tree{}
+		err = Primary(db).Table(model.TableName()).
+			Where("id=?", parentId).
+			Select("id", "code", "path").
+			First(parent).Error
+		if utils.RecordNotFound(err) {
+			return "", "", errors.Wrapf(err, "上级%s不存在", model.TableTitle())
 		}
+		if err != nil {
+			return "", "", errors.WithStack(err)
+		}
+		if parent.GetPath() == model.GetPath() || strings.HasPrefix(parent.GetPath(), model.GetPath()+":") {
+			return "", "", errors.Errorf("不可将%s移动到自己的下级", model.TableTitle())
+		}
+		parentPath = parent.GetPath()
+		key = fmt.Sprintf("%s:%s", model.TableName(), parentPath)
 	}
-	return nil
+
+	code, err = SequenceService.NextCode(db, key)
+	if err != nil {
+		return "", "", errors.WithStack(err)
+	}
+	if parentPath == "" {
+		return code, code, nil
+	}
+	return code, fmt.Sprintf("%s:%s", parentPath, code), nil
+}
+
+// rewriteTreePath 将子孙节点path的前缀oldPath替换为newPath，并将分配子节点code使用的序列一起改名
+func rewriteTreePath(db *gorm.DB, table string, oldPath string, newPath string) error {
+	err := db.Exec(
+		fmt.Sprintf(
+			"UPDATE %s SET path=%s WHERE path LIKE ? ESCAPE '!'",
+			Quote(db, table), concatSql(db, "?", "SUBSTR(path, ?)"),
+		),
+		newPath, len(oldPath)+1, likePrefix(oldPath+":"),
+	).Error
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return SequenceService.RenamePrefix(db, fmt.Sprintf("%s:%s", table, oldPath), fmt.Sprintf("%s:%s", table, newPath))
+}
+
+// concatSql 拼接字符串，mysql中||为逻辑或，须使用CONCAT
+func concatSql(db *gorm.DB, exprs ...string) string {
+	if db.Dialector.Name() == "mysql" {
+		return "CONCAT(" + strings.Join(exprs, ", ") + ")"
+	}
+	return strings.Join(exprs, " || ")
+}
+
+// likePrefix 前缀匹配的LIKE参数，配合ESCAPE '!'使用，code中可能包含_
+func likePrefix(prefix string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(prefix) + "%"
 }
 
 func DeleteTree(db *gorm.DB, model TreeType, ids []int64) (err error) {
@@ -371,10 +456,10 @@ func DeleteTree(db *gorm.DB, model TreeType, ids []int64) (err error) {
 			return errors.WithStack(err)
 		}
 		model.SetId(0)
-		err = db.Delete(model, "path like ?", model.GetPath()+"%").Error
+		err = db.Scopes(subtreeScope(model.GetPath())).Delete(model).Error
 		if err != nil {
 			return errors.WithStack(err)
 		}
 	}
-	return nil
+	return invalidateCache(db)
 }
@@ -1,8 +1,9 @@
 package orm
 
 import (
+	"context"
 	"fmt"
-	"gorm.io/driver/postgres"
+	"github.com/vuuvv/orca/id"
 	"gorm.io/gorm"
 	"reflect"
 	"testing"
@@ -24,23 +25,217 @@ func testF[T any]() any {
 	//return reflect.TypeOf(t)
 }
 
+type testMenu struct {
+	Tree
+	Name string `gorm:"uniqueIndex"`
+}
+
+func (*testMenu) TableName() string {
+	return "t_menu"
+}
+
+func (*testMenu) TableTitle() string {
+	return "菜单"
+}
+
+// newTestDB 创建内存sqlite数据库，用于不依赖外部服务的测试
+func newTestDB(t *testing.T, models ...interface{}) *gorm.DB {
+	t.Helper()
+	return newTestDBWithConfig(t, &Config{}, models...)
+}
+
+// newTestDBWithConfig 使用config创建内存sqlite数据库，config的Type和Dsn被忽略
+func newTestDBWithConfig(t *testing.T, config *Config, models ...interface{}) *gorm.DB {
+	t.Helper()
+	if g, err := id.NewGenerator(); err == nil {
+		id.ReplaceGlobal(g)
+	} else {
+		t.Fatal(err)
+	}
+	config.Type = "sqlite"
+	config.Dsn = ""
+	db, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		_ = sqlDB.Close()
+	})
+	err = db.AutoMigrate(append([]interface{}{&Sequence{}}, models...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
 func TestString(t *testing.T) {
 	ss := Sequence{}
 	t.Log(tableTitle(&ss))
 	t.Log(fmt.Sprint(&ss))
 	t.Log(testF[Sequence]())
-	db, err := gorm.Open(postgres.Open("host=192.168.137.100 user=postgres password=1111aaaa dbname=unisoftcn_user port=5432 sslmode=disable TimeZone=Asia/Shanghai"), &gorm.Config{})
+	db := newTestDB(t)
+	_, err := SequenceService.NextId(db, "t_menu:DAAA")
 	if err != nil {
-		t.Log(err)
-		return
+		t.Fatal(err)
 	}
 	s, err := GetBy[Sequence](db, "key", "t_menu:DAAA")
-	t.Log(s)
 	if err != nil {
-		t.Log(err)
-		return
+		t.Fatal(err)
+	}
+	if s.Value != 1 {
+		t.Fatalf("expected 1, got %d", s.Value)
 	}
 	a := testA{Name: "a"}
 	t.Log(a)
 	t.Log(fmt.Sprintf("%s", a))
 }
+
+func TestSqlite(t *testing.T) {
+	db := newTestDB(t, &testMenu{})
+
+	if exp, got := "`key`", Quote(db, "key"); exp != got {
+		t.Fatalf("expected %s, got %s", exp, got)
+	}
+
+	for i := 1; i <= 3; i++ {
+		v, err := SequenceService.NextId(db, "seq")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != i {
+			t.Fatalf("expected %d, got %d", i, v)
+		}
+	}
+
+	root := &testMenu{Name: "root"}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return CreateTree(tx, root, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := &testMenu{Name: "child"}
+	child.ParentId = root.GetId()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return CreateTree(tx, child, root)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := root.Path + ":" + child.Code; child.Path != exp {
+		t.Fatalf("expected path %s, got %s", exp, child.Path)
+	}
+
+	err = db.Create(&testMenu{Name: "root"}).Error
+	if !IsDuplicateError(err) {
+		t.Fatalf("expected duplicate error, got %v", err)
+	}
+	err = DuplicateMessage(err, map[string]string{"t_menu.name": "菜单名称重复"})
+	if err.Error() != "菜单名称重复" {
+		t.Fatalf("unexpected message: %v", err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return DeleteTree(tx, &testMenu{}, []int64{root.GetId()})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&testMenu{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("expected tree to be deleted, got %d", count)
+	}
+}
+
+func TestMoveTree(t *testing.T) {
+	db := newTestDB(t, &testMenu{})
+	create := func(name string, parent *testMenu) *testMenu {
+		menu := &testMenu{Name: name}
+		var parentNode TreeType
+		if parent != nil {
+			menu.ParentId = parent.GetId()
+			parentNode = parent
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return CreateTree(tx, menu, parentNode)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return menu
+	}
+	move := func(menu *testMenu, parentId int64) error {
+		form := &testMenu{Name: menu.Name}
+		form.Id = menu.Id
+		form.ParentId = parentId
+		return db.Transaction(func(tx *gorm.DB) error {
+			return UpdateTree(tx, &testMenu{}, form)
+		})
+	}
+	get := func(menu *testMenu) *testMenu {
+		ret := &testMenu{}
+		if err := db.First(ret, menu.GetId()).Error; err != nil {
+			t.Fatal(err)
+		}
+		return ret
+	}
+
+	a := create("a", nil)
+	b := create("b", nil)
+	c := create("c", a)
+	d := create("d", c)
+
+	if err := move(c, b.GetId()); err != nil {
+		t.Fatal(err)
+	}
+	c = get(c)
+	if c.ParentId != b.GetId() || c.Path != b.Path+":"+c.Code {
+		t.Fatalf("unexpected moved node: %+v", c)
+	}
+	if got := get(d); got.Path != c.Path+":"+d.Code {
+		t.Fatalf("expected descendant path %s, got %s", c.Path+":"+d.Code, got.Path)
+	}
+	// 移动后子节点的序列跟随移动，新建的子节点code不重复
+	e := create("e", c)
+	if e.Code == d.Code {
+		t.Fatalf("expected new code, got %s", e.Code)
+	}
+
+	if err := move(b, d.GetId()); err == nil {
+		t.Fatal("expected error when moving under descendant")
+	}
+	if err := move(c, 0); err != nil {
+		t.Fatal(err)
+	}
+	c = get(c)
+	if c.ParentId != 0 || c.Path != c.Code {
+		t.Fatalf("unexpected root node: %+v", c)
+	}
+	if got := get(e); got.Path != c.Path+":"+e.Code {
+		t.Fatalf("expected descendant path %s, got %s", c.Path+":"+e.Code, got.Path)
+	}
+}
+
+func TestPingReplicas(t *testing.T) {
+	db, err := New(&Config{Type: "sqlite", Replicas: []string{":memory:"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+
+	ctx := context.Background()
+	if err = Ping(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if stats := ReplicaStats(db); len(stats) != 1 {
+		t.Fatalf("expected 1 replica, got %d", len(stats))
+	}
+
+	_ = replicasOf(db)[0].Close()
+	if err = Ping(ctx, db); err == nil {
+		t.Fatal("expected broken replica to fail the ping")
+	}
+}
@@ -0,0 +1,160 @@
+package orm
+
+import (
+	"fmt"
+	"github.com/vuuvv/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+)
+
+const (
+	versionField       = "Version"
+	versionExpectedKey = "orca:version_expected"
+)
+
+// Versioned 乐观锁的版本号，与Entity一起嵌入模型即可启用。创建时为1，每次更新加1，
+// 更新时版本号与数据库中不一致返回*ConflictError，客户端须提交读取时的version
+type Versioned struct {
+	Version int64 `json:"version" gorm:"not null;default:1;comment:版本号"`
+}
+
+// VersionedType 使用乐观锁的模型
+type VersionedType interface {
+	GetVersion() int64
+	SetVersion(value int64)
+}
+
+func (v *Versioned) GetVersion() int64 {
+	return v.Version
+}
+
+func (v *Versioned) SetVersion(value int64) {
+	v.Version = value
+}
+
+// ConflictError 记录已被其他人修改，http返回409
+type ConflictError struct {
+	Title   string
+	Id      int64
+	Version int64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s已被修改，请刷新后重试", e.Title)
+}
+
+// IsConflictError 是否为乐观锁冲突
+func IsConflictError(err error) bool {
+	var conflict *ConflictError
+	return errors.As(err, &conflict)
+}
+
+// versionPlugin 创建时版本号为1，按主键更新单条记录时检查并递增版本号，UpdateColumn(s)等跳过钩子的更新不处理
+type versionPlugin struct{}
+
+func (p *versionPlugin) Name() string {
+	return "orca:version"
+}
+
+func (p *versionPlugin) Initialize(db *gorm.DB) error {
+	err := db.Callback().Create().Before("gorm:create").Register("orca:version_create", p.beforeCreate)
+	if err != nil {
+		return err
+	}
+	err = db.Callback().Update().Before("gorm:update").Register("orca:version_update", p.beforeUpdate)
+	if err != nil {
+		return err
+	}
+	return db.Callback().Update().After("gorm:update").Register("orca:version_check", p.afterUpdate)
+}
+
+func versionedField(stmt *gorm.Statement) *schema.Field {
+	if stmt.Schema == nil {
+		return nil
+	}
+	if _, ok := reflect.New(stmt.Schema.ModelType).Interface().(VersionedType); !ok {
+		return nil
+	}
+	return stmt.Schema.LookUpField(versionField)
+}
+
+func (p *versionPlugin) beforeCreate(db *gorm.DB) {
+	stmt := db.Statement
+	field := versionedField(stmt)
+	if field == nil || db.Error != nil {
+		return
+	}
+	set := func(rv reflect.Value) {
+		rv = reflect.Indirect(rv)
+		if _, zero := field.ValueOf(stmt.Context, rv); zero {
+			_ = field.Set(stmt.Context, rv, int64(1))
+		}
+	}
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			set(stmt.ReflectValue.Index(i))
+		}
+	case reflect.Struct:
+		set(stmt.ReflectValue)
+	}
+}
+
+// expectedVersion 更新前的版本号，Updates的参数为同类型的结构体时(例如Repository.Update的form)以参数为准
+func expectedVersion(stmt *gorm.Statement, field *schema.Field) int64 {
+	dest := reflect.Indirect(reflect.ValueOf(stmt.Dest))
+	if dest.Kind() == reflect.Struct && dest.Type() == stmt.Schema.ModelType {
+		value, _ := field.ValueOf(stmt.Context, dest)
+		return value.(int64)
+	}
+	value, _ := field.ValueOf(stmt.Context, stmt.ReflectValue)
+	return value.(int64)
+}
+
+func (p *versionPlugin) beforeUpdate(db *gorm.DB) {
+	stmt := db.Statement
+	if stmt.SkipHooks || db.Error != nil || stmt.ReflectValue.Kind() != reflect.Struct {
+		return
+	}
+	field := versionedField(stmt)
+	pk := stmt.Schema.PrioritizedPrimaryField
+	if field == nil || pk == nil {
+		return
+	}
+	// 只处理按主键更新单条记录，Model(&T{}).Where(...)的批量更新不检查
+	if _, zero := pk.ValueOf(stmt.Context, stmt.ReflectValue); zero {
+		return
+	}
+
+	expected := expectedVersion(stmt, field)
+	if expected == 0 {
+		// 模型和参数都不是从数据库读取的，条件不会匹配任何记录，不应报告为冲突
+		_ = db.AddError(errors.Errorf("%s缺少版本号", tableTitle(reflect.New(stmt.Schema.ModelType).Interface())))
+		return
+	}
+	db.InstanceSet(versionExpectedKey, expected)
+	stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: expected},
+	}})
+	stmt.SetColumn(field.DBName, expected+1, true)
+	if len(stmt.Selects) > 0 && !contains(stmt.Selects, "*") && !contains(stmt.Selects, field.DBName) && !contains(stmt.Selects, field.Name) {
+		stmt.Selects = append(stmt.Selects, field.DBName)
+	}
+}
+
+func (p *versionPlugin) afterUpdate(db *gorm.DB) {
+	val, ok := db.InstanceGet(versionExpectedKey)
+	if !ok || db.Error != nil || db.RowsAffected > 0 {
+		return
+	}
+	stmt := db.Statement
+	expected := val.(int64)
+	field := versionedField(stmt)
+	_ = field.Set(stmt.Context, stmt.ReflectValue, expected)
+	id, _ := stmt.Schema.PrioritizedPrimaryField.ValueOf(stmt.Context, stmt.ReflectValue)
+	conflict := &ConflictError{Title: tableTitle(reflect.New(stmt.Schema.ModelType).Interface()), Version: expected}
+	conflict.Id, _ = id.(int64)
+	_ = db.AddError(conflict)
+}
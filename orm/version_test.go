@@ -0,0 +1,89 @@
+package orm
+
+import (
+	"context"
+	"testing"
+)
+
+type testDoc struct {
+	Id
+	Entity
+	Versioned
+	Title string `gorm:"uniqueIndex"`
+	Body  string
+}
+
+func (*testDoc) TableName() string {
+	return "t_doc"
+}
+
+func (*testDoc) TableTitle() string {
+	return "文档"
+}
+
+func TestOptimisticLock(t *testing.T) {
+	db := newTestDB(t, &testDoc{})
+	repo := NewRepository[*testDoc](db)
+	ctx := context.Background()
+
+	doc := &testDoc{Title: "a"}
+	if err := repo.Create(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != 1 {
+		t.Fatalf("expected version 1, got %d", doc.Version)
+	}
+
+	form := &testDoc{Id: doc.Id, Versioned: Versioned{Version: 1}, Title: "a", Body: "b"}
+	updated, err := repo.Update(ctx, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Version != 2 {
+		t.Fatalf("expected version 2, got %d", updated.Version)
+	}
+
+	// 未提交版本号
+	err = db.Model(&testDoc{Id: doc.Id}).Update("body", "x").Error
+	if err == nil || IsConflictError(err) || err.Error() != "文档缺少版本号" {
+		t.Fatalf("expected missing version error, got %v", err)
+	}
+
+	// 使用旧的版本号更新
+	stale := &testDoc{Id: doc.Id, Versioned: Versioned{Version: 1}, Title: "a", Body: "c"}
+	_, err = repo.Update(ctx, stale)
+	if !IsConflictError(err) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+	err = Update(db, &testDoc{}, &testDoc{Id: doc.Id, Versioned: Versioned{Version: 1}, Title: "a", Body: "c"})
+	if !IsConflictError(err) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+	err = Update(db, &testDoc{}, &testDoc{Id: doc.Id, Versioned: Versioned{Version: 2}, Title: "a", Body: "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Upsert(db, []*testDoc{{Title: "a", Body: "d"}}, []string{"title"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.Get(ctx, doc.GetId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Body != "d" || got.Version != 4 {
+		t.Fatalf("unexpected doc: %+v", got)
+	}
+
+	// 批量更新递增版本号，持有旧版本号的更新冲突
+	if _, err = UpdateByIds[*testDoc](db, []int64{doc.GetId()}, map[string]interface{}{"body": "e"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = repo.Update(ctx, &testDoc{Id: doc.Id, Versioned: Versioned{Version: 4}, Title: "a", Body: "f"})
+	if !IsConflictError(err) {
+		t.Fatalf("expected conflict error after batch update, got %v", err)
+	}
+	if got, _ = repo.Get(ctx, doc.GetId()); got.Body != "e" || got.Version != 5 {
+		t.Fatalf("unexpected doc: %+v", got)
+	}
+}
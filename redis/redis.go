@@ -16,6 +16,7 @@ func NewRedisClient(config *Config) (client *redis.Client, err error) {
 	})
 	_, err = client.Ping(context.Background()).Result()
 	if err != nil {
+		_ = client.Close()
 		return nil, errors.WithStack(err)
 	}
 	zap.L().Info("redis客户端初始化成功", zap.String("host", client.Options().Addr))
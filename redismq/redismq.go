@@ -36,27 +36,45 @@ func Produce(cli *redis.Client, queue string, value interface{}) error {
 
 func Consume(cli *redis.Client, queue string, handler func(payload string) error) {
 	defer utils.NormalRecover("Consume")
+	ConsumeContext(context.Background(), cli, queue, handler)
+}
+
+// consumeBlock 每次读取的最长阻塞时间，阻塞期间无法响应ctx的取消
+const consumeBlock = time.Second * 2
+
+// ConsumeContext 消费队列直到ctx结束，正在执行的handler会先执行完。
+// handler的panic不会被捕获，一般配合Application.Go使用，由其负责重启
+func ConsumeContext(ctx context.Context, cli *redis.Client, queue string, handler func(payload string) error) {
 
 	group := groupName(queue)
 	consumer := consumerName(queue)
 
-	for {
-		payload, err := cli.XReadGroup(context.Background(), &redis.XReadGroupArgs{
+	for ctx.Err() == nil {
+		payload, err := cli.XReadGroup(ctx, &redis.XReadGroupArgs{
 			Streams:  []string{queue, ">"},
 			Group:    group,
 			Consumer: consumer,
 			Count:    1,
-			Block:    0,
+			Block:    consumeBlock,
 		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
 		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
 			if strings.HasPrefix(err.Error(), "NOGROUP") {
-				_, err = cli.XGroupCreateMkStream(context.Background(), queue, group, "0").Result()
+				_, err = cli.XGroupCreateMkStream(ctx, queue, group, "0").Result()
 				if err == nil {
 					continue
 				}
 			}
 			zap.L().Error("Consume queue error", zap.String("queue", queue), zap.Error(err))
-			time.Sleep(time.Second * 2)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second * 2):
+			}
 			continue
 		}
 		if len(payload) == 0 {
@@ -90,3 +108,11 @@ func Consume(cli *redis.Client, queue string, handler func(payload string) error
 		}
 	}
 }
+
+// Worker 返回消费队列的后台任务，用于Application.Go
+func Worker(cli *redis.Client, queue string, handler func(payload string) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		ConsumeContext(ctx, cli, queue, handler)
+		return nil
+	}
+}
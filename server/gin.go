@@ -20,6 +20,7 @@ import (
 	pathLib "path"
 	"reflect"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -43,6 +44,11 @@ type GinServer struct {
 	config        *Config
 	routes        []*Route
 	authorization Authorization
+	mu            sync.Mutex
+	srv           *http.Server
+	closed        bool
+	infos         map[string]func() interface{}
+	health        healthChecks
 	//middlewares []gin.HandlerFunc
 }
 
@@ -125,6 +131,31 @@ func (s *GinServer) Default() Server {
 	return s.Use(MiddlewareId, gin.Logger(), gin.Recovery())
 }
 
+func (s *GinServer) AddInfo(name string, fn func() interface{}) Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.infos == nil {
+		s.infos = make(map[string]func() interface{})
+	}
+	s.infos[name] = fn
+	return s
+}
+
+func (s *GinServer) AddHealthCheck(name string, fn HealthCheck) Server {
+	s.health.add(name, fn)
+	return s
+}
+
+func (s *GinServer) info() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make(map[string]interface{}, len(s.infos))
+	for name, fn := range s.infos {
+		ret[name] = fn()
+	}
+	return ret
+}
+
 func (s *GinServer) AddRoute(route *Route) {
 	s.routes = append(s.routes, route)
 }
@@ -158,43 +189,71 @@ func (s *GinServer) Mount(controllers ...interface{}) Server {
 	return s
 }
 
-func (s *GinServer) Start() {
+// Serve 启动http服务，阻塞直到服务出错或被Shutdown，被Shutdown时返回nil，已经Shutdown时不再启动
+func (s *GinServer) Serve() error {
 	s.Mount(&ActuatorController{})
 	//if len(s.middlewares) > 0 {
 	//	s.gin.Use(s.middlewares...)
 	//}
 
-	srv := &http.Server{
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.srv = &http.Server{
 		Addr:    s.addr(),
 		Handler: s.gin,
 	}
+	srv := s.srv
+	s.mu.Unlock()
 
+	zap.L().Info("启动http服务", zap.String("addr", srv.Addr))
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Shutdown 停止接收新的请求，并等待处理中的请求完成，直到ctx结束
+func (s *GinServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	srv := s.srv
+	s.mu.Unlock()
+	if srv == nil {
+		return nil
+	}
+	zap.L().Info("Shutting down server...")
+	if err := srv.Shutdown(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+	zap.L().Info("Server exited")
+	return nil
+}
+
+// Start 启动http服务，收到SIGINT或SIGTERM后在5秒内关闭。
+// 只关闭http服务，需要关闭其他资源时使用Application.Start
+func (s *GinServer) Start() {
 	go func() {
-		zap.L().Info("启动http服务", zap.String("addr", srv.Addr))
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := s.Serve(); err != nil {
 			zap.L().Panic("启动http服务失败", zap.Error(err))
 		}
 	}()
 
-	// Wait for interrupt signal to gracefully shutdown the server with
-	// a timeout of 5 seconds.
-	quit := make(chan os.Signal)
 	// kill (no param) default send syscall.SIGTERM
 	// kill -2 is syscall.SIGINT
 	// kill -9 is syscall.SIGKILL but can't be caught, so don't need to add it
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	zap.L().Info("Shutting down server...")
 
-	// The context is used to inform the server it has 5 seconds to finish
-	// the request it is currently handling
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
+	if err := s.Shutdown(ctx); err != nil {
 		zap.L().Fatal("Server forced to shutdown", zap.Error(err))
 	}
-	zap.L().Info("Server exited")
 }
 
 type GinController interface {
@@ -394,7 +453,17 @@ func (this *BaseController) SendError(err error) {
 			rawErr = err
 		}
 	}
+	var conflict *orm.ConflictError
+	if errors.As(err, &conflict) {
+		rawErr = conflict
+	}
 	switch e := rawErr.(type) {
+	case *orm.ConflictError:
+		this.SendJson(http.StatusConflict, &Response{
+			Code:    http.StatusConflict,
+			Message: e.Error(),
+			Detail:  fmt.Sprintf("%+v", err),
+		})
 	case govalidator.Error:
 		this.SendJson(http.StatusBadRequest, &Response{
 			Code:    http.StatusBadRequest,
@@ -444,10 +513,16 @@ func (this *ActuatorController) Mount(router *gin.RouterGroup) {
 	this.Get("health", this.health).Anonymous().WithName("健康检测")
 	this.Get("env", this.env).WithName("查看环境变量")
 	this.Get("routes", this.routes).WithName("查看所有路由")
+	this.Get("info", this.info).WithName("查看监控信息")
 }
 
 func (this *ActuatorController) health(ctx *gin.Context) {
-	ctx.String(http.StatusOK, "OK")
+	code, body := this.server.health.check(ctx.Request.Context())
+	ctx.String(code, body)
+}
+
+func (this *ActuatorController) info(ctx *gin.Context) {
+	this.Send(this.server.info())
 }
 
 func (this *ActuatorController) routes(ctx *gin.Context) {
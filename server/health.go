@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"github.com/vuuvv/errors"
+	"go.uber.org/zap"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const healthCheckTimeout = 3 * time.Second
+
+// HealthCheck 健康检测项，例如ping数据库，返回错误表示不健康
+type HealthCheck func(ctx context.Context) error
+
+type healthChecks struct {
+	mu     sync.Mutex
+	checks map[string]HealthCheck
+}
+
+func (h *healthChecks) add(name string, fn HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.checks == nil {
+		h.checks = make(map[string]HealthCheck)
+	}
+	h.checks[name] = fn
+}
+
+// check 执行所有检测项，全部通过时返回200和"OK"，否则返回503和失败项的名称。
+// 健康检测不需要登录，错误中可能包含主机名、连接串等信息，只记录到日志
+func (h *healthChecks) check(ctx context.Context) (int, string) {
+	h.mu.Lock()
+	names := make([]string, 0, len(h.checks))
+	checks := make(map[string]HealthCheck, len(h.checks))
+	for name, fn := range h.checks {
+		names = append(names, name)
+		checks[name] = fn
+	}
+	h.mu.Unlock()
+	sort.Strings(names)
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	var failed []string
+	for _, name := range names {
+		if err := checks[name](ctx); err != nil {
+			zap.L().Error("健康检测失败", zap.String("check", name), zap.Error(err))
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		return http.StatusServiceUnavailable, strings.Join(failed, "\n")
+	}
+	return http.StatusOK, "OK"
+}
+
+// HealthServer 只提供健康检测的http服务，用于不启动GinServer的worker模式
+type HealthServer struct {
+	addr   string
+	mu     sync.Mutex
+	srv    *http.Server
+	closed bool
+	health healthChecks
+}
+
+func NewHealthServer(addr string) *HealthServer {
+	return &HealthServer{addr: addr}
+}
+
+// AddHealthCheck 添加健康检测项，任一项失败时_m_/health返回503
+func (s *HealthServer) AddHealthCheck(name string, fn HealthCheck) *HealthServer {
+	s.health.add(name, fn)
+	return s
+}
+
+// Serve 启动健康检测服务，阻塞直到服务出错或被Shutdown，被Shutdown时返回nil，已经Shutdown时不再启动
+func (s *HealthServer) Serve() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_m_/health", func(w http.ResponseWriter, r *http.Request) {
+		code, body := s.health.check(r.Context())
+		w.WriteHeader(code)
+		_, _ = w.Write([]byte(body))
+	})
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.srv = &http.Server{
+		Addr:    s.addr,
+		Handler: mux,
+	}
+	srv := s.srv
+	s.mu.Unlock()
+
+	zap.L().Info("启动健康检测服务", zap.String("addr", srv.Addr))
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (s *HealthServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	srv := s.srv
+	s.mu.Unlock()
+	if srv == nil {
+		return nil
+	}
+	return errors.WithStack(srv.Shutdown(ctx))
+}
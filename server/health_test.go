@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHealthChecks(t *testing.T) {
+	h := &healthChecks{}
+	if code, body := h.check(context.Background()); code != http.StatusOK || body != "OK" {
+		t.Fatalf("expected 200 OK, got %d %s", code, body)
+	}
+
+	h.add("redis", func(ctx context.Context) error { return nil })
+	h.add("database", func(ctx context.Context) error { return errors.New("connection refused") })
+	code, body := h.check(context.Background())
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", code)
+	}
+	if body != "database" {
+		t.Fatalf("unexpected body: %s", body)
+	}
+}
+
+func TestShutdownBeforeServe(t *testing.T) {
+	s := NewHealthServer("127.0.0.1:0")
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		_ = s.srv.Close()
+		t.Fatal("Serve started after Shutdown")
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
 	"github.com/vuuvv/errors"
 	"github.com/vuuvv/goid"
@@ -12,6 +13,7 @@ import (
 
 var contexts = sync.Map{} //map[int64]*gin.Context{}
 const AccessTokenContextKey = "AccessToken"
+const RequestIdHeader = "X-Request-Id"
 
 func MiddlewareId(ctx *gin.Context) {
 	id := goid.Get()
@@ -48,6 +50,44 @@ func GetAccessToken() *AccessToken {
 	return at
 }
 
+// ginContext ctx不是*gin.Context时从MiddlewareId记录的请求中获取
+func ginContext(ctx context.Context) (*gin.Context, bool) {
+	if gc, ok := ctx.(*gin.Context); ok {
+		return gc, true
+	}
+	val, exists := contexts.Load(goid.Get())
+	if !exists {
+		return nil, false
+	}
+	return val.(*gin.Context), true
+}
+
+// AccessUserId 获取当前请求的用户id，ctx不是*gin.Context时从MiddlewareId记录的请求中获取，没有登录用户时返回0
+func AccessUserId(ctx context.Context) int64 {
+	gc, ok := ginContext(ctx)
+	if !ok {
+		return 0
+	}
+	val, ok := gc.Get(AccessTokenContextKey)
+	if !ok {
+		return 0
+	}
+	at, ok := val.(*AccessToken)
+	if !ok || at == nil {
+		return 0
+	}
+	return at.GetId()
+}
+
+// AccessRequestId 获取当前请求的X-Request-Id请求头，不在请求中时返回空字符串
+func AccessRequestId(ctx context.Context) string {
+	gc, ok := ginContext(ctx)
+	if !ok || gc.Request == nil {
+		return ""
+	}
+	return gc.GetHeader(RequestIdHeader)
+}
+
 func MiddlewareJwt(config *Config, authorization Authorization) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		guard := authorization.GetGuard(ctx.Request)
@@ -1,5 +1,7 @@
 package server
 
+import "context"
+
 type Server interface {
 	Start()
 	GetConfig() *Config
@@ -9,3 +11,23 @@ type Server interface {
 	Use(handlers ...interface{}) Server
 	Default() Server
 }
+
+// InfoServer 可展示监控信息的Server，通过_m_/info查看
+type InfoServer interface {
+	// AddInfo 添加监控信息
+	AddInfo(name string, fn func() interface{}) Server
+}
+
+// HealthCheckServer 可通过_m_/health报告健康状态的Server
+type HealthCheckServer interface {
+	// AddHealthCheck 添加健康检测项，任一项失败时_m_/health返回503
+	AddHealthCheck(name string, fn HealthCheck) Server
+}
+
+// GracefulServer 可由Application启动和关闭的Server，未实现时Application调用Start，由Server自己处理退出信号
+type GracefulServer interface {
+	// Serve 启动服务，阻塞直到服务出错或被Shutdown，被Shutdown时返回nil
+	Serve() error
+	// Shutdown 停止服务并等待处理中的请求完成，在Serve之前调用时Serve不再启动
+	Shutdown(ctx context.Context) error
+}
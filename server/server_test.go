@@ -3,7 +3,11 @@ package server
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/orca/orm"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 )
@@ -45,3 +49,26 @@ func TestServer(t *testing.T) {
 		Mode: gin.DebugMode,
 	}).Mount(&indexController{}).Start()
 }
+
+func TestSendConflictError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(MiddlewareId)
+	engine.GET("/doc", func(ctx *gin.Context) {
+		conflict := &orm.ConflictError{Title: "文档", Id: 1, Version: 2}
+		(&BaseController{server: &GinServer{config: &Config{Mode: gin.TestMode}}}).SendError(errors.Wrapf(conflict, "更新失败: %v", conflict))
+	})
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/doc", nil))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", w.Code)
+	}
+	var resp Response
+	if err := jsoniter.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != http.StatusConflict || resp.Message != "文档已被修改，请刷新后重试" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+}
@@ -0,0 +1,74 @@
+package orca
+
+import (
+	"fmt"
+	"github.com/vuuvv/errors"
+	"go.uber.org/zap"
+	"time"
+)
+
+// StartupError 应用初始化失败，Component为失败的组件，例如config、redis、database
+type StartupError struct {
+	Component string
+	Err       error
+}
+
+func (e *StartupError) Error() string {
+	return fmt.Sprintf("初始化[%s]失败: %v", e.Component, e.Err)
+}
+
+func (e *StartupError) Unwrap() error {
+	return e.Err
+}
+
+func startupError(component string, err error) error {
+	return errors.WithStackAndSkip(&StartupError{Component: component, Err: err}, 1)
+}
+
+// RetryConfig 启动时连接redis、数据库等依赖的重试策略，用于依赖服务与应用同时启动的场景
+type RetryConfig struct {
+	// Attempts 最多尝试次数，小于等于1表示不重试
+	Attempts int `json:"attempts"`
+	// Interval 首次重试的间隔，之后每次翻倍，默认1秒
+	Interval time.Duration `json:"interval"`
+	// MaxInterval 最大重试间隔，默认30秒
+	MaxInterval time.Duration `json:"maxInterval"`
+}
+
+// WithStartupRetry 启动时连接redis、数据库失败的重试次数和首次重试间隔
+func WithStartupRetry(attempts int, interval time.Duration) ApplicationOption {
+	return func(app *Application) {
+		app.config.Retry.Attempts = attempts
+		app.config.Retry.Interval = interval
+	}
+}
+
+// retry 按重试策略执行fn，直到成功或达到最大次数
+func (c *RetryConfig) retry(component string, fn func() error) (err error) {
+	interval := c.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	maxInterval := c.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = 30 * time.Second
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= c.Attempts {
+			return err
+		}
+		zap.L().Warn("初始化失败，准备重试",
+			zap.String("component", component),
+			zap.Int("attempt", attempt),
+			zap.Duration("interval", interval),
+			zap.Error(err),
+		)
+		time.Sleep(interval)
+		interval *= 2
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package orca
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewApplicationE(t *testing.T) {
+	_, err := NewApplicationE(WithConfigPath(filepath.Join(t.TempDir(), "missing.yaml")))
+	var startupErr *StartupError
+	if !errors.As(err, &startupErr) || startupErr.Component != "config" {
+		t.Fatalf("expected config startup error, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "application.yaml")
+	err = os.WriteFile(path, []byte("zap:\n  level: wrong\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewApplicationE(WithConfigPath(path))
+	if !errors.As(err, &startupErr) || startupErr.Component != "zap" {
+		t.Fatalf("expected zap startup error, got %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	c := &RetryConfig{Attempts: 3, Interval: time.Millisecond}
+	calls := 0
+	err := c.retry("test", func() error {
+		calls++
+		return errors.New("fail")
+	})
+	if err == nil || calls != 3 {
+		t.Fatalf("expected 3 failed calls, got %d, %v", calls, err)
+	}
+
+	calls = 0
+	err = c.retry("test", func() error {
+		calls++
+		if calls < 2 {
+			return errors.New("fail")
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("expected success after 2 calls, got %d, %v", calls, err)
+	}
+}
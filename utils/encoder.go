@@ -3,6 +3,8 @@ package utils
 import (
 	"fmt"
 	"github.com/vuuvv/errors"
+	"math"
+	"strings"
 )
 
 const encodeURL = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
@@ -48,3 +50,97 @@ func DecodeBase64LikeToInt(value string) (int, error) {
 	}
 	return result, nil
 }
+
+// Base64LikeCodec EncodeIntToBase64Like的编码，固定4个字符，最大值为2^24-1，编码结果不能按字典序排序
+type Base64LikeCodec struct{}
+
+func (Base64LikeCodec) Encode(value int) (string, error) {
+	return EncodeIntToBase64Like(value)
+}
+
+func (Base64LikeCodec) Decode(code string) (int, error) {
+	return DecodeBase64LikeToInt(code)
+}
+
+// SortableAlphabet SortableCodec默认使用的字符，按ASCII升序，不区分大小写的排序规则下也不会冲突
+const SortableAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// SortableCodec 可按字典序排序的变长编码。首字符表示位数，后面是大端序的数字，
+// 位数不足MinWidth时补齐到MinWidth，超出时自动加长，位数多的编码首字符更大，因此字典序与数值大小一致
+type SortableCodec struct {
+	// Alphabet 使用的字符，须按ASCII升序且不重复，不能包含路径分隔符:
+	Alphabet string
+	// MinWidth 最小位数，不包括首字符
+	MinWidth int
+}
+
+// NewSortableCodec 创建可排序的编码，alphabet为空时使用SortableAlphabet
+func NewSortableCodec(alphabet string, minWidth int) (*SortableCodec, error) {
+	if alphabet == "" {
+		alphabet = SortableAlphabet
+	}
+	if len(alphabet) < 2 {
+		return nil, errors.New("编码字符至少需要2个")
+	}
+	for i := 1; i < len(alphabet); i++ {
+		if alphabet[i-1] >= alphabet[i] {
+			return nil, errors.Errorf("编码字符须按ASCII升序且不重复: %s", alphabet)
+		}
+	}
+	if strings.ContainsAny(alphabet, ":%_!") {
+		return nil, errors.Errorf("编码字符不能包含:%%_!: %s", alphabet)
+	}
+	if minWidth < 1 {
+		minWidth = 1
+	}
+	if minWidth > len(alphabet) {
+		return nil, errors.Errorf("最小位数不能超过编码字符的数量%d", len(alphabet))
+	}
+	return &SortableCodec{Alphabet: alphabet, MinWidth: minWidth}, nil
+}
+
+func (c *SortableCodec) Encode(value int) (string, error) {
+	if value < 0 {
+		return "", errors.Errorf("不支持负数: %d", value)
+	}
+	base := len(c.Alphabet)
+	var digits []byte
+	for v := value; v > 0; v /= base {
+		digits = append(digits, c.Alphabet[v%base])
+	}
+	for len(digits) < c.MinWidth {
+		digits = append(digits, c.Alphabet[0])
+	}
+	if len(digits) > base {
+		return "", errors.Errorf("超出编码范围: %d", value)
+	}
+	code := make([]byte, 0, len(digits)+1)
+	code = append(code, c.Alphabet[len(digits)-1])
+	for i := len(digits) - 1; i >= 0; i-- {
+		code = append(code, digits[i])
+	}
+	return string(code), nil
+}
+
+func (c *SortableCodec) Decode(code string) (int, error) {
+	if len(code) < 2 {
+		return 0, errors.Errorf("编码格式错误: %s", code)
+	}
+	width := strings.IndexByte(c.Alphabet, code[0]) + 1
+	if width == 0 || width != len(code)-1 {
+		return 0, errors.Errorf("编码格式错误: %s", code)
+	}
+	base := len(c.Alphabet)
+	var result int
+	for i := 1; i < len(code); i++ {
+		digit := strings.IndexByte(c.Alphabet, code[i])
+		if digit < 0 {
+			return 0, errors.Errorf("编码格式错误: %s", code)
+		}
+		if result > (math.MaxInt-digit)/base {
+			return 0, errors.Errorf("超出解析范围: %s", code)
+		}
+		result = result*base + digit
+	}
+	return result, nil
+}
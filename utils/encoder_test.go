@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/fatih/structs"
 	"github.com/stretchr/testify/assert"
-	"github.com/vuuvv/orca/orm"
 	"math/rand"
 	"testing"
 )
@@ -40,8 +39,16 @@ func TestEncodeIntToBase64LikeRandom(t *testing.T) {
 	}
 }
 
+// EmbeddedTree 与orm.Tree结构相同，utils不能引用orm
+type EmbeddedTree struct {
+	Id       int64
+	ParentId int64
+	Code     string
+	Path     string
+}
+
 type EmbeddedA struct {
-	orm.Tree
+	EmbeddedTree
 	Name string
 	Age  int
 }
@@ -54,7 +61,7 @@ type EmbeddedB struct {
 
 func TestStructsEmbedded(t *testing.T) {
 	a := &EmbeddedA{Name: "a", Age: 10}
-	a.Tree.ParentId = 1
+	a.EmbeddedTree.ParentId = 1
 	b := &EmbeddedB{ParentId: 2, Name: "b", Age: 10}
 
 	sa := structs.New(a)
@@ -68,6 +75,30 @@ func TestStructsEmbedded(t *testing.T) {
 	}
 }
 
+func TestSortableCodec(t *testing.T) {
+	codec, err := NewSortableCodec("", 2)
+	assert.NoError(t, err)
+	var last string
+	for _, v := range []int{0, 1, 35, 36, 1295, 1296, 46655, 46656, 1 << 40} {
+		e, err := codec.Encode(v)
+		assert.NoError(t, err)
+		d, err := codec.Decode(e)
+		assert.NoError(t, err)
+		assert.Equal(t, v, d)
+		assert.True(t, last < e, "%s应小于%s", last, e)
+		last = e
+	}
+	e, _ := codec.Encode(1295)
+	assert.Equal(t, "1ZZ", e)
+	e, _ = codec.Encode(1296)
+	assert.Equal(t, "2100", e)
+
+	_, err = NewSortableCodec("BA", 1)
+	assert.Error(t, err)
+	_, err = codec.Decode("3ZZ")
+	assert.Error(t, err)
+}
+
 func TestRand(t *testing.T) {
 	fmt.Println(rand.Intn(2))
 }
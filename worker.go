@@ -0,0 +1,175 @@
+package orca
+
+import (
+	"context"
+	"github.com/vuuvv/errors"
+	"github.com/vuuvv/orca/utils"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const (
+	WorkerPending    = "pending"
+	WorkerRunning    = "running"
+	WorkerRestarting = "restarting"
+	WorkerFinished   = "finished"
+	WorkerStopped    = "stopped"
+)
+
+const (
+	workerMinBackoff = time.Second
+	workerMaxBackoff = time.Minute
+	// workerStableTime 运行超过该时间后，再次出错时重新从最小间隔开始重启
+	workerStableTime = time.Minute
+)
+
+type WorkerFunc func(ctx context.Context) error
+
+type WorkerStatus struct {
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Restarts  int       `json:"restarts"`
+	LastError string    `json:"lastError,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+type worker struct {
+	name   string
+	fn     WorkerFunc
+	mu     sync.Mutex
+	status WorkerStatus
+}
+
+func (w *worker) setState(state string, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status.State = state
+	if state == WorkerRunning {
+		w.status.StartedAt = time.Now()
+	}
+	if state == WorkerRestarting {
+		w.status.Restarts++
+	}
+	if err != nil {
+		w.status.LastError = err.Error()
+	}
+}
+
+func (w *worker) getStatus() WorkerStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+func (w *worker) runOnce(ctx context.Context) (err error) {
+	defer utils.Catch("worker "+w.name, func(reason interface{}) {
+		err = errors.Errorf("panic: %v", reason)
+	})
+	return w.fn(ctx)
+}
+
+// run 执行任务，出错或panic时按指数退避重启，ctx结束或任务正常返回时退出
+func (w *worker) run(ctx context.Context) {
+	backoff := workerMinBackoff
+	for {
+		w.setState(WorkerRunning, nil)
+		start := time.Now()
+		err := w.runOnce(ctx)
+		if ctx.Err() != nil {
+			w.setState(WorkerStopped, err)
+			return
+		}
+		if err == nil {
+			w.setState(WorkerFinished, nil)
+			return
+		}
+
+		if time.Since(start) > workerStableTime {
+			backoff = workerMinBackoff
+		}
+		w.setState(WorkerRestarting, err)
+		zap.L().Error("后台任务异常，准备重启", zap.String("worker", w.name), zap.Duration("backoff", backoff), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			w.setState(WorkerStopped, nil)
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > workerMaxBackoff {
+			backoff = workerMaxBackoff
+		}
+	}
+}
+
+// Go 注册后台任务，应用启动后执行，应用已启动时立即执行。
+// fn应在ctx结束时尽快返回；返回错误或panic时会按指数退避重启，正常返回则不再执行。
+// 任务状态可通过_m_/info查看
+func (this *Application) Go(name string, fn WorkerFunc) *Application {
+	w := &worker{name: name, fn: fn, status: WorkerStatus{Name: name, State: WorkerPending}}
+
+	this.workerMu.Lock()
+	this.workers = append(this.workers, w)
+	started := this.started
+	this.workerMu.Unlock()
+
+	if started {
+		this.startWorker(w)
+	}
+	return this
+}
+
+// Workers 返回所有后台任务的状态
+func (this *Application) Workers() []WorkerStatus {
+	this.workerMu.Lock()
+	defer this.workerMu.Unlock()
+	ret := make([]WorkerStatus, 0, len(this.workers))
+	for _, w := range this.workers {
+		ret = append(ret, w.getStatus())
+	}
+	return ret
+}
+
+func (this *Application) startWorker(w *worker) {
+	this.workerWg.Add(1)
+	go func() {
+		defer this.workerWg.Done()
+		zap.L().Info("启动后台任务", zap.String("worker", w.name))
+		w.run(this.ctx)
+	}()
+}
+
+func (this *Application) startWorkers() {
+	this.workerMu.Lock()
+	this.started = true
+	workers := make([]*worker, len(this.workers))
+	copy(workers, this.workers)
+	this.workerMu.Unlock()
+
+	for _, w := range workers {
+		this.startWorker(w)
+	}
+}
+
+// waitWorkers 等待所有后台任务退出，直到ctx结束
+func (this *Application) waitWorkers(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		this.workerWg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		var running []string
+		for _, s := range this.Workers() {
+			if s.State == WorkerRunning || s.State == WorkerRestarting {
+				running = append(running, s.Name)
+			}
+		}
+		return errors.Errorf("等待后台任务退出超时: %v", running)
+	}
+}
@@ -0,0 +1,47 @@
+package orca
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerRestart(t *testing.T) {
+	app := &Application{config: &Config{}}
+	app.ctx, app.cancel = context.WithCancel(context.Background())
+	app.registerStopHooks()
+
+	var runs int32
+	app.Go("panic-once", func(ctx context.Context) error {
+		if atomic.AddInt32(&runs, 1) == 1 {
+			panic("boom")
+		}
+		<-ctx.Done()
+		return nil
+	})
+	app.Go("finish", func(ctx context.Context) error {
+		return nil
+	})
+	app.startWorkers()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&runs) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&runs) != 2 {
+		t.Fatalf("expected worker to be restarted, runs %d", runs)
+	}
+
+	if err := app.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	status := app.Workers()
+	if status[0].State != WorkerStopped || status[0].Restarts != 1 || status[0].LastError == "" {
+		t.Fatalf("unexpected status %+v", status[0])
+	}
+	if status[1].State != WorkerFinished {
+		t.Fatalf("unexpected status %+v", status[1])
+	}
+}